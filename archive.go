@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/files"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/state"
+)
+
+// cursorPolicy sets what archivePosts does with the cursors of the accounts
+// whose posts it writes.
+type cursorPolicy string
+
+const (
+	// cursorsAdvance moves the cursors to the bounds of the fetched posts.
+	cursorsAdvance cursorPolicy = "advance"
+	// cursorsKeep leaves the cursors where they were, and only records the
+	// run, for posts that leave gaps between their bounds.
+	cursorsKeep cursorPolicy = "keep"
+	// cursorsIgnore leaves the accounts as they were, for posts that were
+	// not fetched, such as the archived ones that are rendered again.
+	cursorsIgnore cursorPolicy = "ignore"
+)
+
+// archivePosts writes the posts of each client, records them in the state,
+// and updates the accounts as the cursor policy says before saving the
+// state. Nothing is saved when the writer only plans its operations. It
+// stops after the post being written when ctx is done, in which case the
+// cursors are left where they were, so that the next run fetches the
+// remaining posts again.
+func archivePosts(ctx context.Context, archiveState *state.State, fileWriter *files.FileWriter, cursors cursorPolicy, clients ...*client.Client) error {
+	interrupted := false
+	// Saves the manifest when a post cannot be written, so that the files
+	// already written are not mistaken for local edits on the next run.
+	defer fileWriter.Close()
+
+write:
+	for _, c := range clients {
+		for _, post := range c.Posts() {
+			if ctx.Err() != nil {
+				interrupted = true
+				break write
+			}
+
+			if err := fileWriter.Write(post); err != nil {
+				return fmt.Errorf("error writing post to file: %w", err)
+			}
+
+			path, err := fileWriter.Path(post)
+
+			if err != nil {
+				return err
+			}
+
+			if err := archiveState.Record(c.Account(), post, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if fileWriter.DryRun() {
+		return ctx.Err()
+	}
+
+	if err := fileWriter.Close(); err != nil {
+		return err
+	}
+
+	if cursors != cursorsIgnore {
+		now := time.Now()
+
+		for _, c := range clients {
+			source := archiveState.Source(c.Account())
+
+			if cursors == cursorsAdvance && !interrupted {
+				newest, oldest := c.Bounds()
+				source.Update(newest, oldest, now)
+			} else {
+				source.LastRun = now
+			}
+		}
+
+		archiveState.LastRun = now
+	}
+
+	if err := archiveState.Save(); err != nil {
+		return err
+	}
+
+	return ctx.Err()
+}
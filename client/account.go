@@ -2,6 +2,8 @@ package client
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -28,20 +30,63 @@ type Account struct {
 	LastStatusAt   string    `json:"last_status_at"`
 }
 
+// FetchAccount looks up an account by its handle. Servers without the lookup
+// endpoint, such as older Pleroma and GoToSocial releases, are searched
+// instead, and Pleroma also accepts the handle in place of an id.
 func FetchAccount(baseURL string, handle string) (Account, error) {
 	var account Account
 	lookupUrl := fmt.Sprintf(
 		"%s/api/v1/accounts/lookup?acct=%s",
 		baseURL,
-		handle,
+		url.QueryEscape(handle),
 	)
 
 	headers := make(map[string]string)
+	setAuthTokenIfPassed(&headers, lookupUrl)
 	err := Fetch(lookupUrl, &account, headers)
 
-	if err != nil {
-		return account, err
+	if err == nil {
+		return account, nil
 	}
 
-	return account, nil
+	if account, searchErr := searchAccount(baseURL, handle, headers); searchErr == nil {
+		return account, nil
+	}
+
+	username, _, _ := strings.Cut(handle, "@")
+
+	for _, id := range []string{handle, username} {
+		accountUrl := fmt.Sprintf("%s/api/v1/accounts/%s", baseURL, url.PathEscape(id))
+
+		if Fetch(accountUrl, &account, headers) == nil && account.Id != "" {
+			return account, nil
+		}
+	}
+
+	return account, err
+}
+
+func searchAccount(baseURL, handle string, headers map[string]string) (Account, error) {
+	var accounts []Account
+
+	searchUrl := fmt.Sprintf(
+		"%s/api/v1/accounts/search?%s",
+		baseURL,
+		url.Values{"q": {handle}, "limit": {"5"}, "resolve": {"false"}}.Encode(),
+	)
+
+	if err := Fetch(searchUrl, &accounts, headers); err != nil {
+		return Account{}, err
+	}
+
+	username, domain, _ := strings.Cut(handle, "@")
+
+	for _, account := range accounts {
+		// Local accounts are listed by username alone.
+		if account.Acct == handle || (account.Acct == username && strings.Contains(baseURL, domain)) {
+			return account, nil
+		}
+	}
+
+	return Account{}, fmt.Errorf("account %s not found", handle)
 }
@@ -0,0 +1,345 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Audience addressed by public activities.
+const PublicCollection = "https://www.w3.org/ns/activitystreams#Public"
+
+// Activity is an ActivityStreams activity, as found in an actor's outbox.
+type Activity struct {
+	Id        string    `json:"id"`
+	Type      string    `json:"type"`
+	Actor     Link      `json:"actor"`
+	Published time.Time `json:"published"`
+	To        Links     `json:"to"`
+	Cc        Links     `json:"cc"`
+	// Either the object itself, or its id.
+	Object json.RawMessage `json:"object"`
+}
+
+// Note is the ActivityStreams object of a status.
+type Note struct {
+	Id           string            `json:"id"`
+	Type         string            `json:"type"`
+	Summary      string            `json:"summary"`
+	InReplyTo    Link              `json:"inReplyTo"`
+	Published    time.Time         `json:"published"`
+	URL          Link              `json:"url"`
+	AttributedTo Link              `json:"attributedTo"`
+	To           Links             `json:"to"`
+	Cc           Links             `json:"cc"`
+	Sensitive    bool              `json:"sensitive"`
+	Content      string            `json:"content"`
+	ContentMap   map[string]string `json:"contentMap"`
+	Attachment   []Document        `json:"attachment"`
+	Tag          []ObjectTag       `json:"tag"`
+}
+
+// Document is a media attachment of a Note.
+type Document struct {
+	Type       string    `json:"type"`
+	MediaType  string    `json:"mediaType"`
+	URL        Link      `json:"url"`
+	Name       string    `json:"name"`
+	Blurhash   string    `json:"blurhash"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	FocalPoint []float64 `json:"focalPoint"`
+}
+
+type ObjectTag struct {
+	Type string `json:"type"`
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+// Actor is the ActivityStreams document of an account.
+type Actor struct {
+	Id                string    `json:"id"`
+	Type              string    `json:"type"`
+	PreferredUsername string    `json:"preferredUsername"`
+	Name              string    `json:"name"`
+	Summary           string    `json:"summary"`
+	URL               Link      `json:"url"`
+	Published         time.Time `json:"published"`
+	Icon              Document  `json:"icon"`
+	Image             Document  `json:"image"`
+	Outbox            string    `json:"outbox"`
+	Followers         string    `json:"followers"`
+}
+
+// OrderedCollection is either a collection or one of its pages.
+type OrderedCollection struct {
+	Id           string     `json:"id"`
+	Type         string     `json:"type"`
+	TotalItems   int        `json:"totalItems"`
+	First        Link       `json:"first"`
+	Next         Link       `json:"next"`
+	OrderedItems []Activity `json:"orderedItems"`
+}
+
+// Link is a property which refers to another object. It may be given as the
+// object's URL, as a Link object, or as a list of either, in which case the
+// HTML representation is preferred.
+type Link string
+
+func (l *Link) UnmarshalJSON(data []byte) error {
+	var value interface{}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	*l = Link(linkHref(value))
+
+	return nil
+}
+
+func linkHref(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		for _, key := range []string{"href", "id", "url"} {
+			if href, ok := v[key].(string); ok {
+				return href
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if link, ok := item.(map[string]interface{}); ok && link["mediaType"] == "text/html" {
+				return linkHref(link)
+			}
+		}
+
+		if len(v) > 0 {
+			return linkHref(v[0])
+		}
+	}
+
+	return ""
+}
+
+// Links is a property which may hold a single reference or a list of them.
+type Links []string
+
+func (l *Links) UnmarshalJSON(data []byte) error {
+	var value interface{}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	*l = nil
+
+	if items, ok := value.([]interface{}); ok {
+		for _, item := range items {
+			*l = append(*l, linkHref(item))
+		}
+	} else if href := linkHref(value); href != "" {
+		*l = Links{href}
+	}
+
+	return nil
+}
+
+// Account converts an actor into the account its posts are attributed to.
+// Without the Mastodon API the account has no numeric id, so its id is the
+// actor's id.
+func (a Actor) Account() Account {
+	account := Account{
+		Id:           a.Id,
+		Username:     a.PreferredUsername,
+		Acct:         a.PreferredUsername,
+		DisplayName:  a.Name,
+		Bot:          a.Type == "Service" || a.Type == "Application",
+		Group:        a.Type == "Group",
+		CreatedAt:    a.Published,
+		Note:         a.Summary,
+		URL:          string(a.URL),
+		URI:          a.Id,
+		Avatar:       string(a.Icon.URL),
+		AvatarStatic: string(a.Icon.URL),
+		Header:       string(a.Image.URL),
+		HeaderStatic: string(a.Image.URL),
+	}
+
+	if account.URL == "" {
+		account.URL = a.Id
+	}
+
+	if parsedURL, err := url.Parse(a.Id); err == nil && parsedURL.Host != "" {
+		account.Acct = fmt.Sprintf("%s@%s", a.PreferredUsername, parsedURL.Host)
+	}
+
+	return account
+}
+
+// Post converts an activity of the actor into the status it published.
+// Create activities of notes become posts, and Announce activities become
+// boosts, which have no content of their own. Other activities are ignored,
+// in which case the returned bool is false.
+func (a Activity) Post(actor Actor) (Post, bool, error) {
+	account := actor.Account()
+
+	switch a.Type {
+	case "Announce":
+		var object Link
+
+		if err := json.Unmarshal(a.Object, &object); err != nil {
+			return Post{}, false, fmt.Errorf("error reading announced object of %s: %w", a.Id, err)
+		}
+
+		return Post{
+			CreatedAt:  a.Published,
+			Id:         StatusId(a.Id),
+			Visibility: visibility(actor, a.To, a.Cc),
+			URI:        a.Id,
+			URL:        string(object),
+			Account:    account,
+			Reblog: &Post{
+				URI: string(object),
+				URL: string(object),
+			},
+		}, true, nil
+	case "Create":
+		var note Note
+
+		// Objects given by reference would have to be fetched.
+		if err := json.Unmarshal(a.Object, &note); err != nil {
+			return Post{}, false, nil
+		}
+
+		if !slices.Contains([]string{"Note", "Article", "Question", "Page"}, note.Type) {
+			return Post{}, false, nil
+		}
+
+		return note.Post(actor), true, nil
+	}
+
+	return Post{}, false, nil
+}
+
+// Post converts the note into a post of the actor.
+func (n Note) Post(actor Actor) Post {
+	account := actor.Account()
+	post := Post{
+		CreatedAt:   n.Published,
+		Id:          StatusId(n.Id),
+		Visibility:  visibility(actor, n.To, n.Cc),
+		Sensitive:   n.Sensitive,
+		SpoilerText: n.Summary,
+		URI:         n.Id,
+		URL:         string(n.URL),
+		Content:     n.Content,
+		Account:     account,
+	}
+
+	if post.URL == "" {
+		post.URL = n.Id
+	}
+
+	// Replies to the actor's own posts are threaded by id. Replies to other
+	// accounts keep the parent's URI, which cannot collide with an id.
+	if n.InReplyTo != "" {
+		post.InReplyToId = string(n.InReplyTo)
+
+		if strings.HasPrefix(string(n.InReplyTo), actor.Id+"/") {
+			post.InReplyToId = StatusId(string(n.InReplyTo))
+			post.InReplyToAccountId = account.Id
+		}
+	}
+
+	for language := range n.ContentMap {
+		post.Language = language
+		break
+	}
+
+	for i, document := range n.Attachment {
+		post.MediaAttachments = append(post.MediaAttachments, document.MediaAttachment(fmt.Sprintf("%s-%d", post.Id, i)))
+	}
+
+	for _, tag := range n.Tag {
+		if tag.Type != "Hashtag" {
+			continue
+		}
+
+		post.Tags = append(post.Tags, Tag{
+			Name: strings.ToLower(strings.TrimPrefix(tag.Name, "#")),
+			URL:  tag.Href,
+		})
+	}
+
+	return post
+}
+
+// Mastodon stores attachments under a path made of the digits of their id.
+var mediaIdPath = regexp.MustCompile(`/media_attachments/files/((?:\d+/)+)`)
+
+// MediaAttachment converts the document into an attachment. defaultId is
+// used when the attachment's id cannot be told from its URL.
+func (d Document) MediaAttachment(defaultId string) MediaAttachment {
+	media := MediaAttachment{
+		Type:        "unknown",
+		URL:         string(d.URL),
+		Description: d.Name,
+		Id:          defaultId,
+		Blurhash:    d.Blurhash,
+		Meta: MediaMeta{
+			Original: MediaDimensions{
+				Width:  d.Width,
+				Height: d.Height,
+			},
+		},
+	}
+
+	if d.Width > 0 && d.Height > 0 {
+		media.Meta.Original.Aspect = float64(d.Width) / float64(d.Height)
+	}
+
+	if len(d.FocalPoint) == 2 {
+		media.Meta.Focus = MediaFocus{X: d.FocalPoint[0], Y: d.FocalPoint[1]}
+	}
+
+	mediaType, _, _ := strings.Cut(d.MediaType, "/")
+
+	switch mediaType {
+	case "image", "video", "audio":
+		media.Type = mediaType
+	}
+
+	if match := mediaIdPath.FindStringSubmatch(media.URL); match != nil {
+		media.Id = strings.ReplaceAll(match[1], "/", "")
+	}
+
+	return media
+}
+
+// StatusId tells the id of a status from its URI or the URI of its activity,
+// which end with the id on Mastodon and most other servers.
+func StatusId(uri string) string {
+	uri = strings.TrimSuffix(strings.TrimSuffix(uri, "/"), "/activity")
+
+	return uri[strings.LastIndex(uri, "/")+1:]
+}
+
+func visibility(actor Actor, to, cc Links) string {
+	switch {
+	case slices.Contains(to, PublicCollection) || slices.Contains(to, "as:Public") || slices.Contains(to, "Public"):
+		return "public"
+	case slices.Contains(cc, PublicCollection) || slices.Contains(cc, "as:Public") || slices.Contains(cc, "Public"):
+		return "unlisted"
+	case actor.Followers != "" && slices.Contains(to, actor.Followers):
+		return "private"
+	}
+
+	return "direct"
+}
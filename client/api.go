@@ -1,19 +1,85 @@
 package client
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"sync/atomic"
 )
 
+// Amount of requests sent to servers' APIs, by every client.
+var requests atomic.Int64
+
+// Requests returns the amount of requests sent to servers' APIs so far.
+func Requests() int {
+	return int(requests.Load())
+}
+
+// Largest amount of an error response's body that is kept.
+const maxErrorBodySize = 64 * 1024
+
+// ResponseError is returned when the API responds with a non-2xx status.
+type ResponseError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	// Start of the response's body, in which some APIs describe the error.
+	Body []byte
+}
+
+func (e ResponseError) Error() string {
+	return fmt.Sprintf("unexpected status fetching %s: %s", e.URL, e.Status)
+}
+
+// IsNotFound reports whether err is a 404 or 410 response from the API.
+func IsNotFound(err error) bool {
+	var responseError ResponseError
+
+	if !errors.As(err, &responseError) {
+		return false
+	}
+
+	return responseError.StatusCode == http.StatusNotFound || responseError.StatusCode == http.StatusGone
+}
+
 func Fetch(requestUrl string, variable interface{}, headers map[string]string) error {
-	client := &http.Client{}
 	req, _ := http.NewRequest("GET", requestUrl, nil)
 
+	return do(req, variable, headers)
+}
+
+// FetchJSON sends body as JSON in a POST request, which is how APIs such as
+// Misskey's are queried, and decodes the response into variable.
+func FetchJSON(requestUrl string, body interface{}, variable interface{}, headers map[string]string) error {
+	data, err := json.Marshal(body)
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", requestUrl, bytes.NewReader(data))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	return do(req, variable, headers)
+}
+
+func do(req *http.Request, variable interface{}, headers map[string]string) error {
+	client := &http.Client{}
+	requestUrl := req.URL.String()
+
 	for key, val := range headers {
 		req.Header.Set(key, val)
 	}
 
+	requests.Add(1)
 	res, err := client.Do(req)
 
 	if err != nil {
@@ -22,8 +88,23 @@ func Fetch(requestUrl string, variable interface{}, headers map[string]string) e
 
 	defer res.Body.Close()
 
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+
+		return ResponseError{
+			URL:        requestUrl,
+			StatusCode: res.StatusCode,
+			Status:     res.Status,
+			Body:       body,
+		}
+	}
+
 	body, err := io.ReadAll(res.Body)
 
+	if err != nil {
+		return err
+	}
+
 	if err := json.Unmarshal(body, variable); err != nil {
 		return err
 	}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/credentials"
+)
+
+// Map of host:Credential. A token is only ever sent to the host it belongs
+// to, so that fetching from other servers does not leak it.
+var tokens = make(map[string]credentials.Credential)
+
+// UseCredentials sends the tokens in store to the instances they were
+// obtained from.
+func UseCredentials(store *credentials.Store) {
+	for host, credential := range store.Instances {
+		tokens[host] = credential
+	}
+}
+
+// Binds the token of the MASTODON_AUTH_TOKEN variable to a single instance.
+var envToken sync.Once
+
+// useEnvToken scopes the token of the MASTODON_AUTH_TOKEN variable to the
+// instance at baseURL, the first time an account is resolved. It is never
+// bound to another instance afterwards, so that archiving several accounts,
+// such as with the daemon or every profile, does not send it to their
+// instances. It takes precedence over a token stored for the same instance.
+func useEnvToken(baseURL string) {
+	envToken.Do(func() {
+		token, ok := os.LookupEnv("MASTODON_AUTH_TOKEN")
+
+		if !ok || token == "" {
+			return
+		}
+
+		host, err := credentials.Host(baseURL)
+
+		if err != nil {
+			return
+		}
+
+		tokens[host] = credentials.Credential{
+			Server:      baseURL,
+			AccessToken: token,
+		}
+	})
+}
+
+// setAuthTokenIfPassed authorizes the request to requestUrl with the token
+// of its host, if there is one.
+func setAuthTokenIfPassed(headers *map[string]string, requestUrl string) {
+	host, err := credentials.Host(requestUrl)
+
+	if err != nil {
+		return
+	}
+
+	if credential, ok := tokens[host]; ok && credential.AccessToken != "" {
+		(*headers)["Authorization"] = fmt.Sprintf("Bearer %s", credential.AccessToken)
+	}
+}
+
+// Authorization describes what the token of an instance grants.
+type Authorization struct {
+	Host string
+	// Account the token was obtained for.
+	Account Account
+	// Scopes granted to the token, when the instance or the credentials
+	// file tell them.
+	Scopes []string
+}
+
+// ReadsStatuses reports whether the token is known to read statuses, such
+// as those that are only visible to followers.
+func (a Authorization) ReadsStatuses() bool {
+	return slices.ContainsFunc(a.Scopes, func(scope string) bool {
+		return scope == "read" || scope == "read:statuses"
+	})
+}
+
+type appCredentials struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// VerifyCredentials checks the token of the instance at baseURL, and returns
+// the account and scopes it grants. It reports false when there is no
+// token for the instance.
+func VerifyCredentials(baseURL string) (Authorization, bool, error) {
+	var authorization Authorization
+	host, err := credentials.Host(baseURL)
+
+	if err != nil {
+		return authorization, false, err
+	}
+
+	credential, ok := tokens[host]
+
+	if !ok || credential.AccessToken == "" {
+		return authorization, false, nil
+	}
+
+	authorization.Host = host
+	headers := make(map[string]string)
+	setAuthTokenIfPassed(&headers, baseURL)
+
+	if err := Fetch(baseURL+"/api/v1/accounts/verify_credentials", &authorization.Account, headers); err != nil {
+		return authorization, true, fmt.Errorf("the token for %s was rejected: %w", host, err)
+	}
+
+	// Only Mastodon 4.3 and later list the scopes of the application.
+	var app appCredentials
+
+	if err := Fetch(baseURL+"/api/v1/apps/verify_credentials", &app, headers); err == nil && len(app.Scopes) > 0 {
+		authorization.Scopes = app.Scopes
+	} else if credential.Scope != "" {
+		authorization.Scopes = strings.Fields(credential.Scope)
+	}
+
+	return authorization, true, nil
+}
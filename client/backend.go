@@ -0,0 +1,89 @@
+package client
+
+// Backend reads an account's posts from where they are published. The
+// client threads and filters the posts a backend returns the same way,
+// whatever the backend.
+type Backend interface {
+	// Account returns the account whose posts are read.
+	Account() Account
+	// Posts returns a batch of the account's posts, newest first, applying
+	// the filters as Mastodon's statuses endpoint does.
+	Posts(filters PostsFilter) (Page, error)
+	// Context returns the ancestors of a post, oldest first, and the posts
+	// in the replies below it.
+	Context(postId string) (StatusContext, error)
+}
+
+// Page is a batch of posts read from a backend.
+type Page struct {
+	// Posts of the batch, newest first.
+	Posts []Post
+	// Ids of the newest and oldest posts the server returned, including
+	// those that the backend filtered out itself, to page on from. They are
+	// empty when the server returned nothing.
+	Newest string
+	Oldest string
+}
+
+// newPage returns a page of posts that the server filtered, newest first.
+func newPage(posts []Post) Page {
+	if len(posts) == 0 {
+		return Page{}
+	}
+
+	return Page{
+		Posts:  posts,
+		Newest: posts[0].Id,
+		Oldest: posts[len(posts)-1].Id,
+	}
+}
+
+// deletionChecker is implemented by backends whose servers cannot be asked
+// through the Mastodon API whether a post still exists.
+type deletionChecker interface {
+	IsDeleted(postId string) (bool, error)
+}
+
+// mastodonBackend reads posts through the Mastodon API, or the API of a
+// server compatible with it.
+type mastodonBackend struct {
+	baseURL  string
+	account  Account
+	software Software
+}
+
+func newMastodonBackend(location UserLocation, software Software) (*mastodonBackend, error) {
+	account, err := FetchAccount(location.BaseURL, location.Handle)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &mastodonBackend{
+		baseURL:  location.BaseURL,
+		account:  account,
+		software: software,
+	}, nil
+}
+
+func (b *mastodonBackend) Account() Account {
+	return b.account
+}
+
+func (b *mastodonBackend) Posts(filters PostsFilter) (Page, error) {
+	if filters.Limit > b.software.MaxLimit() {
+		filters.Limit = b.software.MaxLimit()
+	}
+
+	posts, err := FetchPosts(b.baseURL, b.account.Id, filters)
+
+	if err != nil {
+		return Page{}, err
+	}
+
+	return newPage(posts), nil
+}
+
+func (b *mastodonBackend) Context(postId string) (StatusContext, error) {
+	return FetchStatusContext(b.baseURL, postId)
+}
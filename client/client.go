@@ -1,14 +1,25 @@
 package client
 
 import (
+	"cmp"
+	"context"
 	"fmt"
-	"net/url"
 	"strings"
 )
 
+const (
+	// Read posts through the Mastodon API.
+	BackendMastodon = "mastodon"
+	// Read posts from the account's ActivityPub outbox.
+	BackendActivityPub = "activitypub"
+)
+
 type ClientOptions struct {
 	Visibility string
 	Threaded   bool
+	// Where posts are read from. When empty, the backend is picked from the
+	// software the server runs.
+	Backend string
 }
 
 type Client struct {
@@ -26,76 +37,309 @@ type Client struct {
 	// List of Post.Id. Tracks the posts which will be written as individual files.
 	output  []string
 	options ClientOptions
+	backend Backend
+	// What the token of the account's instance grants, if there is one.
+	authorization Authorization
+	authorized    bool
+	// Ids of the newest and oldest fetched posts.
+	newest string
+	oldest string
 }
 
 func New(userURL string, filters PostsFilter, opts ClientOptions) (Client, error) {
-	var client Client
-	parsedURL, err := url.Parse(userURL)
+	client, err := Resolve(userURL, opts)
 
 	if err != nil {
-		return client, fmt.Errorf("error parsing user url: %w", err)
+		return client, err
 	}
 
-	baseURL := fmt.Sprintf("%s://%s", parsedURL.Scheme, parsedURL.Host)
-	acc := strings.TrimPrefix(parsedURL.Path, "/")
-	handle := strings.TrimPrefix(acc, "@")
+	if err := client.Fetch(filters); err != nil {
+		return client, err
+	}
 
-	account, err := FetchAccount(baseURL, handle)
+	return client, nil
+}
+
+// Resolve looks up the account behind user, which is a profile URL or a
+// handle, without fetching any posts.
+func Resolve(user string, opts ClientOptions) (Client, error) {
+	var client Client
+	location, err := ResolveUser(user)
 
 	if err != nil {
 		return client, err
 	}
 
-	posts, err := FetchPosts(baseURL, account.Id, filters)
+	client = Client{
+		baseURL:   location.BaseURL,
+		handle:    location.Handle,
+		postIdMap: make(map[string]*Post),
+		replies:   make(map[string]string),
+		options:   opts,
+	}
+
+	backend := opts.Backend
+	var software Software
+
+	if backend != BackendActivityPub {
+		software = DetectSoftware(location.BaseURL)
+	}
+
+	if backend == "" {
+		backend = BackendMastodon
+
+		if software.IsMisskey() {
+			backend = BackendMisskey
+		}
+	}
+
+	useEnvToken(location.BaseURL)
+
+	switch backend {
+	case BackendMastodon:
+		// Checking the token first tells a rejected token apart from an
+		// account that cannot be found.
+		client.authorization, client.authorized, err = VerifyCredentials(location.BaseURL)
+
+		if err != nil {
+			return client, err
+		}
+
+		client.backend, err = newMastodonBackend(location, software)
+	case BackendMisskey:
+		client.backend, err = newMisskeyBackend(location, software)
+	case BackendActivityPub:
+		var actor Actor
+		actor, err = resolveActor(location, user)
+		client.backend = &outbox{actor: actor}
+	default:
+		err = fmt.Errorf("unknown backend %q, expected mastodon, misskey or activitypub", opts.Backend)
+	}
 
 	if err != nil {
 		return client, err
 	}
 
-	postIdMap := make(map[string]*Post)
-	replies := make(map[string]string)
-	var orphans []string
-	var output []string
+	client.account = client.backend.Account()
 
-	for i := range posts {
-		post := posts[i]
-		postIdMap[post.Id] = &post
-		if !opts.Threaded && !post.ShouldSkip(opts.Visibility) {
-			output = append(output, post.Id)
+	return client, nil
+}
+
+// Fetch retrieves a single batch of the account's posts.
+func (c *Client) Fetch(filters PostsFilter) error {
+	c.reset()
+	c.filters = filters
+	page, err := c.backend.Posts(filters)
+
+	if err != nil {
+		return err
+	}
+
+	return c.ingest(page.Posts)
+}
+
+// Sync retrieves every post newer than newest and every post older than
+// oldest, paginating through as many batches as it takes. Without either
+// cursor, it retrieves the account's entire history. It stops between
+// batches once ctx is done.
+func (c *Client) Sync(ctx context.Context, filters PostsFilter, newest, oldest string) error {
+	c.reset()
+	c.filters = filters
+	var posts []Post
+
+	if newest != "" {
+		cursor := newest
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			pageFilters := filters
+			pageFilters.SinceId = ""
+			pageFilters.MaxId = ""
+			pageFilters.MinId = cursor
+			page, err := c.backend.Posts(pageFilters)
+
+			if err != nil {
+				return err
+			}
+
+			// Stop once there is nothing newer, or if the server ignored
+			// the cursor and returned posts that were already fetched. A
+			// page can hold no posts when the backend filtered them all
+			// out, so its cursors are what tell.
+			if page.Newest == "" || CompareIds(page.Newest, cursor) <= 0 {
+				break
+			}
+
+			posts = append(posts, page.Posts...)
+			cursor = page.Newest
 		}
 	}
 
-	client = Client{
-		baseURL:   baseURL,
-		handle:    handle,
-		filters:   filters,
-		account:   account,
-		postIdMap: postIdMap,
-		replies:   replies,
-		orphans:   orphans,
-		output:    output,
-		options:   opts,
+	if newest == "" || oldest != "" {
+		cursor := oldest
+
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			pageFilters := filters
+			pageFilters.SinceId = ""
+			pageFilters.MinId = ""
+			pageFilters.MaxId = cursor
+			page, err := c.backend.Posts(pageFilters)
+
+			if err != nil {
+				return err
+			}
+
+			if page.Oldest == "" || (cursor != "" && CompareIds(page.Oldest, cursor) >= 0) {
+				break
+			}
+
+			posts = append(posts, page.Posts...)
+			cursor = page.Oldest
+		}
 	}
 
-	if opts.Threaded {
+	// The posts reach back to the cursors they were fetched from, so the
+	// bounds include them.
+	for _, cursor := range []string{newest, oldest} {
+		if cursor != "" {
+			c.trackBounds(cursor)
+		}
+	}
+
+	return c.ingest(posts)
+}
+
+// Add takes in a post that was received rather than fetched, such as one
+// published while watching the account, as if it was the only post fetched.
+// A reply is threaded with the posts above it, which are fetched again.
+func (c *Client) Add(post Post) error {
+	c.reset()
+
+	return c.ingest([]Post{post})
+}
+
+// Refresh fetches the thread of an edited post again, so that the file the
+// post was written to can be written again with the edit.
+func (c *Client) Refresh(post Post) error {
+	c.reset()
+	c.postIdMap[post.Id] = &post
+
+	if !c.options.Threaded {
+		if !post.ShouldSkip(c.options.Visibility) {
+			c.output = append(c.output, post.Id)
+		}
+
+		return nil
+	}
+
+	// Treating the post as an orphan rebuilds its thread from the status
+	// context, from the top post down.
+	c.orphans = append(c.orphans, post.Id)
+
+	return c.buildOrphans()
+}
+
+// reset forgets the posts of a previous fetch, keeping their bounds.
+func (c *Client) reset() {
+	c.postIdMap = make(map[string]*Post)
+	c.replies = make(map[string]string)
+	c.orphans = nil
+	c.output = nil
+}
+
+// ingest tracks a set of fetched posts and decides which of them are
+// written out, threading them if requested.
+func (c *Client) ingest(posts []Post) error {
+	for i := range posts {
+		post := posts[i]
+		c.postIdMap[post.Id] = &post
+		c.trackBounds(post.Id)
+
+		if !c.options.Threaded && !post.ShouldSkip(c.options.Visibility) {
+			c.output = append(c.output, post.Id)
+		}
+	}
+
+	if c.options.Threaded {
 		for _, post := range posts {
-			client.threadPost(post.Id)
+			c.threadPost(post.Id)
 		}
 
-		if len(client.orphans) > 0 {
-			if err := client.buildOrphans(); err != nil {
-				return client, nil
+		if len(c.orphans) > 0 {
+			if err := c.buildOrphans(); err != nil {
+				return err
 			}
 		}
 	}
 
-	return client, nil
+	return nil
+}
+
+func (c *Client) trackBounds(postId string) {
+	if c.newest == "" || CompareIds(postId, c.newest) > 0 {
+		c.newest = postId
+	}
+
+	if c.oldest == "" || CompareIds(postId, c.oldest) < 0 {
+		c.oldest = postId
+	}
+}
+
+// Bounds returns the ids of the newest and oldest posts that were fetched,
+// before any filtering or threading. The posts between them were all
+// fetched, and those of a sync reach back to the cursors it started from.
+func (c Client) Bounds() (string, string) {
+	return c.newest, c.oldest
+}
+
+// CompareIds orders post ids. Mastodon ids are numeric, and ids of other
+// server software are fixed-length strings that sort lexicographically, so
+// shorter ids are older.
+func CompareIds(a, b string) int {
+	if len(a) != len(b) {
+		return cmp.Compare(len(a), len(b))
+	}
+
+	return strings.Compare(a, b)
 }
 
 func (c Client) Account() Account {
 	return c.account
 }
 
+// Authorization returns the account and scopes granted by the token of the
+// account's instance. It reports false when requests are not authorized,
+// or when posts are not read through the Mastodon API.
+func (c Client) Authorization() (Authorization, bool) {
+	return c.authorization, c.authorized
+}
+
+// Software returns the software of the account's server. It is only known
+// when posts are read through the Mastodon or Misskey API.
+func (c Client) Software() Software {
+	switch backend := c.backend.(type) {
+	case *mastodonBackend:
+		return backend.software
+	case *misskeyBackend:
+		return backend.software
+	}
+
+	return Software{}
+}
+
+// Orphans returns the ids of the posts whose parent was not fetched along
+// with them, and whose threads were rebuilt from the status context.
+func (c Client) Orphans() []string {
+	return c.orphans
+}
+
 func (c Client) Posts() []*Post {
 	var posts []*Post
 
@@ -106,9 +350,84 @@ func (c Client) Posts() []*Post {
 	return posts
 }
 
+// Maximum amount of ids accepted by the statuses endpoint in one request.
+const statusesBatchSize = 20
+
+// FindDeleted returns which of the given posts no longer exist. Posts are
+// checked in batches where the server supports it. Posts missing from a
+// batch are checked individually, since a batch also leaves out posts that
+// exist but are not visible.
+func (c Client) FindDeleted(postIds []string) ([]string, error) {
+	var deleted []string
+	var unconfirmed []string
+	batched := c.Software().BatchesStatuses()
+
+	if checker, ok := c.backend.(deletionChecker); ok {
+		for _, postId := range postIds {
+			isDeleted, err := checker.IsDeleted(postId)
+
+			if err != nil {
+				return deleted, err
+			}
+
+			if isDeleted {
+				deleted = append(deleted, postId)
+			}
+		}
+
+		return deleted, nil
+	}
+
+	for start := 0; start < len(postIds); start += statusesBatchSize {
+		batch := postIds[start:min(start+statusesBatchSize, len(postIds))]
+
+		if !batched {
+			unconfirmed = append(unconfirmed, batch...)
+			continue
+		}
+
+		posts, err := FetchStatuses(c.baseURL, batch)
+
+		// Servers without the batch endpoint respond with an error, in which
+		// case every post is checked individually.
+		if err != nil {
+			batched = false
+			unconfirmed = append(unconfirmed, batch...)
+			continue
+		}
+
+		found := make(map[string]bool)
+
+		for _, post := range posts {
+			found[post.Id] = true
+		}
+
+		for _, postId := range batch {
+			if !found[postId] {
+				unconfirmed = append(unconfirmed, postId)
+			}
+		}
+	}
+
+	for _, postId := range unconfirmed {
+		_, err := FetchStatus(c.baseURL, postId)
+
+		if IsNotFound(err) {
+			deleted = append(deleted, postId)
+			continue
+		}
+
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
 func (c *Client) buildOrphans() error {
 	for _, postId := range c.orphans {
-		statusContext, err := FetchStatusContext(c.baseURL, postId)
+		statusContext, err := c.backend.Context(postId)
 
 		if err != nil {
 			return err
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
+)
+
+// ReadExport reads the account and posts of an archive requested from
+// Mastodon's settings. The archive's outbox.json lists the account's
+// activities, and actor.json describes the account.
+//
+// The URLs of attachments included in the archive are file URLs of their
+// path within it, so they can be read without the instance.
+func ReadExport(archive *zip.Reader) (Account, []Post, error) {
+	var actor Actor
+	var outbox OrderedCollection
+
+	if err := readArchiveJSON(archive, "actor.json", &actor); err != nil {
+		return Account{}, nil, err
+	}
+
+	if err := readArchiveJSON(archive, "outbox.json", &outbox); err != nil {
+		return Account{}, nil, err
+	}
+
+	var posts []Post
+
+	for _, activity := range outbox.OrderedItems {
+		post, ok, err := activity.Post(actor)
+
+		if err != nil {
+			return Account{}, nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		for i := range post.MediaAttachments {
+			media := &post.MediaAttachments[i]
+			name := strings.TrimPrefix(media.URL, "/")
+
+			if _, err := fs.Stat(archive, name); err == nil {
+				media.URL = (&url.URL{Scheme: "file", Path: "/" + name}).String()
+			}
+		}
+
+		posts = append(posts, post)
+	}
+
+	return actor.Account(), posts, nil
+}
+
+func readArchiveJSON(archive *zip.Reader, name string, variable interface{}) error {
+	data, err := fs.ReadFile(archive, name)
+
+	if err != nil {
+		return fmt.Errorf("error reading %s from archive: %w", name, err)
+	}
+
+	if err := json.Unmarshal(data, variable); err != nil {
+		return fmt.Errorf("error reading %s from archive: %w", name, err)
+	}
+
+	return nil
+}
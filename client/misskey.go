@@ -0,0 +1,488 @@
+package client
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Read posts through the API of Misskey and its forks.
+const BackendMisskey = "misskey"
+
+// Media type of Misskey's markup, MFM.
+const misskeyMarkup = "text/x.misskeymarkdown"
+
+// Largest amount of notes Misskey returns at once.
+const misskeyMaxLimit = 100
+
+// Software which shares Misskey's API.
+var misskeySoftware = []string{"misskey", "sharkey", "firefish", "iceshrimp", "cherrypick", "foundkey", "calckey"}
+
+// IsMisskey reports whether the software is Misskey or one of its forks.
+func (s Software) IsMisskey() bool {
+	return slices.Contains(misskeySoftware, s.Name)
+}
+
+type MisskeyUser struct {
+	Id             string        `json:"id"`
+	Username       string        `json:"username"`
+	Host           string        `json:"host"`
+	Name           string        `json:"name"`
+	Description    string        `json:"description"`
+	AvatarURL      string        `json:"avatarUrl"`
+	BannerURL      string        `json:"bannerUrl"`
+	CreatedAt      time.Time     `json:"createdAt"`
+	IsBot          bool          `json:"isBot"`
+	IsLocked       bool          `json:"isLocked"`
+	NotesCount     int           `json:"notesCount"`
+	FollowersCount int           `json:"followersCount"`
+	FollowingCount int           `json:"followingCount"`
+	PinnedNotes    []MisskeyNote `json:"pinnedNotes"`
+}
+
+type MisskeyNote struct {
+	Id           string            `json:"id"`
+	CreatedAt    time.Time         `json:"createdAt"`
+	UserId       string            `json:"userId"`
+	Text         string            `json:"text"`
+	CW           string            `json:"cw"`
+	Visibility   string            `json:"visibility"`
+	RenoteCount  int               `json:"renoteCount"`
+	RepliesCount int               `json:"repliesCount"`
+	Reactions    map[string]int    `json:"reactions"`
+	Emojis       map[string]string `json:"emojis"`
+	// Images of the custom emoji used in reactions.
+	ReactionEmojis map[string]string `json:"reactionEmojis"`
+	Files          []MisskeyFile     `json:"files"`
+	ReplyId        string            `json:"replyId"`
+	Reply          *MisskeyNote      `json:"reply"`
+	RenoteId       string            `json:"renoteId"`
+	Renote         *MisskeyNote      `json:"renote"`
+	Tags           []string          `json:"tags"`
+	URI            string            `json:"uri"`
+	URL            string            `json:"url"`
+	User           MisskeyUser       `json:"user"`
+}
+
+type MisskeyFile struct {
+	Id           string `json:"id"`
+	Type         string `json:"type"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	Comment      string `json:"comment"`
+	Blurhash     string `json:"blurhash"`
+	IsSensitive  bool   `json:"isSensitive"`
+	Properties   struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"properties"`
+}
+
+// misskeyError is the body of the API's error responses.
+type misskeyError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type misskeyStatusFields Post
+
+// misskeyStatus is the JSON a note is archived as: a status, along with the
+// fields that Post.UnmarshalJSON reads from other servers' statuses, which
+// Post itself leaves out. Its reblog is archived in the same way.
+type misskeyStatus struct {
+	misskeyStatusFields
+	ContentType string          `json:"content_type,omitempty"`
+	Reactions   []Reaction      `json:"reactions,omitempty"`
+	Reblog      json.RawMessage `json:"reblog"`
+}
+
+// misskeyBackend reads posts through the API of Misskey and its forks,
+// which take POST requests with JSON bodies.
+type misskeyBackend struct {
+	baseURL  string
+	user     MisskeyUser
+	software Software
+}
+
+func newMisskeyBackend(location UserLocation, software Software) (*misskeyBackend, error) {
+	var user MisskeyUser
+	username, _, _ := strings.Cut(location.Handle, "@")
+
+	body := map[string]interface{}{
+		"username": username,
+		"host":     nil,
+	}
+
+	if err := FetchJSON(location.BaseURL+"/api/users/show", body, &user, map[string]string{}); err != nil {
+		return nil, err
+	}
+
+	return &misskeyBackend{
+		baseURL:  location.BaseURL,
+		user:     user,
+		software: software,
+	}, nil
+}
+
+func (b *misskeyBackend) Account() Account {
+	return Account{
+		Id:             b.user.Id,
+		Username:       b.user.Username,
+		Acct:           b.user.Username,
+		DisplayName:    b.user.Name,
+		Locked:         b.user.IsLocked,
+		Bot:            b.user.IsBot,
+		CreatedAt:      b.user.CreatedAt,
+		Note:           b.user.Description,
+		URL:            fmt.Sprintf("%s/@%s", b.baseURL, b.user.Username),
+		URI:            fmt.Sprintf("%s/users/%s", b.baseURL, b.user.Id),
+		Avatar:         b.user.AvatarURL,
+		AvatarStatic:   b.user.AvatarURL,
+		Header:         b.user.BannerURL,
+		HeaderStatic:   b.user.BannerURL,
+		FollowersCount: b.user.FollowersCount,
+		FollowingCount: b.user.FollowingCount,
+		StatusesCount:  b.user.NotesCount,
+	}
+}
+
+// Posts maps the filters onto /api/users/notes. Misskey's sinceId behaves
+// like Mastodon's min_id, returning the notes right after it, so both are
+// sent as sinceId.
+func (b *misskeyBackend) Posts(filters PostsFilter) (Page, error) {
+	var notes []MisskeyNote
+
+	if filters.Pinned {
+		notes = b.user.PinnedNotes
+	} else {
+		body := map[string]interface{}{
+			"userId":      b.user.Id,
+			"limit":       min(max(filters.Limit, 1), misskeyMaxLimit),
+			"withReplies": !filters.ExcludeReplies,
+			"withRenotes": !filters.ExcludeReblogs,
+			"withFiles":   filters.OnlyMedia,
+		}
+
+		if filters.MaxId != "" {
+			body["untilId"] = filters.MaxId
+		}
+
+		if filters.MinId != "" {
+			body["sinceId"] = filters.MinId
+		} else if filters.SinceId != "" {
+			body["sinceId"] = filters.SinceId
+		}
+
+		if err := FetchJSON(b.baseURL+"/api/users/notes", body, &notes, map[string]string{}); err != nil {
+			return Page{}, err
+		}
+	}
+
+	// Notes that are not tagged are left out here rather than by the
+	// server, so the page's cursors are those of every note returned.
+	var page Page
+
+	for _, note := range notes {
+		if page.Newest == "" || CompareIds(note.Id, page.Newest) > 0 {
+			page.Newest = note.Id
+		}
+
+		if page.Oldest == "" || CompareIds(note.Id, page.Oldest) < 0 {
+			page.Oldest = note.Id
+		}
+
+		post, err := b.post(note)
+
+		if err != nil {
+			return Page{}, err
+		}
+
+		if !filters.Matches(post, b.user.Id) {
+			continue
+		}
+
+		page.Posts = append(page.Posts, post)
+	}
+
+	// Notes after a sinceId come oldest first.
+	slices.SortFunc(page.Posts, func(a, c Post) int {
+		return CompareIds(c.Id, a.Id)
+	})
+
+	return page, nil
+}
+
+// Context rebuilds the context of a note from its conversation, which lists
+// its ancestors, and from its children, read recursively.
+func (b *misskeyBackend) Context(postId string) (StatusContext, error) {
+	var statusContext StatusContext
+	var ancestors []MisskeyNote
+
+	body := map[string]interface{}{"noteId": postId, "limit": misskeyMaxLimit}
+
+	if err := FetchJSON(b.baseURL+"/api/notes/conversation", body, &ancestors, map[string]string{}); err != nil {
+		return statusContext, err
+	}
+
+	for _, note := range ancestors {
+		post, err := b.post(note)
+
+		if err != nil {
+			return statusContext, err
+		}
+
+		statusContext.Ancestors = append(statusContext.Ancestors, post)
+	}
+
+	parents := []string{postId}
+
+	for len(parents) > 0 {
+		var children []MisskeyNote
+		body := map[string]interface{}{"noteId": parents[0], "limit": misskeyMaxLimit}
+		parents = parents[1:]
+
+		if err := FetchJSON(b.baseURL+"/api/notes/children", body, &children, map[string]string{}); err != nil {
+			return statusContext, err
+		}
+
+		for _, note := range children {
+			// Children also include quotes, which are not replies.
+			if note.ReplyId == "" {
+				continue
+			}
+
+			post, err := b.post(note)
+
+			if err != nil {
+				return statusContext, err
+			}
+
+			statusContext.Descendants = append(statusContext.Descendants, post)
+			parents = append(parents, note.Id)
+		}
+	}
+
+	slices.SortFunc(statusContext.Ancestors, func(a, c Post) int {
+		return CompareIds(a.Id, c.Id)
+	})
+
+	slices.SortFunc(statusContext.Descendants, func(a, c Post) int {
+		return CompareIds(a.Id, c.Id)
+	})
+
+	return statusContext, nil
+}
+
+// IsDeleted looks the note up. Misskey responds to missing notes with a 400
+// and a NO_SUCH_NOTE error rather than a 404. Other errors, such as invalid
+// parameters, are also a 400, and are returned as they are.
+func (b *misskeyBackend) IsDeleted(postId string) (bool, error) {
+	var note MisskeyNote
+	err := FetchJSON(b.baseURL+"/api/notes/show", map[string]string{"noteId": postId}, &note, map[string]string{})
+
+	var responseError ResponseError
+
+	if errors.As(err, &responseError) && responseError.StatusCode == http.StatusBadRequest {
+		var body misskeyError
+
+		if json.Unmarshal(responseError.Body, &body) == nil && body.Error.Code == "NO_SUCH_NOTE" {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	if IsNotFound(err) {
+		return true, nil
+	}
+
+	return false, err
+}
+
+// post converts a note into a post. The note's MFM text is kept in
+// Post.Text, and rendered as escaped HTML paragraphs in Post.Content.
+func (b *misskeyBackend) post(note MisskeyNote) (Post, error) {
+	account := b.Account()
+
+	if note.UserId != b.user.Id {
+		account = Account{
+			Id:          note.User.Id,
+			Username:    note.User.Username,
+			Acct:        note.User.Username,
+			DisplayName: note.User.Name,
+			Avatar:      note.User.AvatarURL,
+		}
+
+		if note.User.Host != "" {
+			account.Acct = fmt.Sprintf("%s@%s", note.User.Username, note.User.Host)
+		}
+	}
+
+	post := Post{
+		CreatedAt:    note.CreatedAt,
+		Id:           note.Id,
+		Visibility:   misskeyVisibility(note.Visibility),
+		InReplyToId:  note.ReplyId,
+		SpoilerText:  note.CW,
+		Sensitive:    note.CW != "",
+		URI:          note.URI,
+		URL:          note.URL,
+		Content:      mfmToHTML(note.Text),
+		Text:         note.Text,
+		ContentType:  misskeyMarkup,
+		RepliesCount: note.RepliesCount,
+		ReblogsCount: note.RenoteCount,
+		Account:      account,
+	}
+
+	// Local notes have neither URI nor URL.
+	if post.URI == "" {
+		post.URI = fmt.Sprintf("%s/notes/%s", b.baseURL, note.Id)
+	}
+
+	if post.URL == "" {
+		post.URL = post.URI
+	}
+
+	if note.Reply != nil {
+		post.InReplyToAccountId = note.Reply.UserId
+	}
+
+	// A renote without text of its own is a boost. Renotes with text are
+	// quotes, which are kept as posts.
+	if note.Renote != nil && note.Text == "" && len(note.Files) == 0 {
+		renote, err := b.post(*note.Renote)
+
+		if err != nil {
+			return post, err
+		}
+
+		post.Reblog = &renote
+		post.ContentType = ""
+	}
+
+	for _, file := range note.Files {
+		mediaType, _, _ := strings.Cut(file.Type, "/")
+		media := MediaAttachment{
+			Id:          file.Id,
+			Type:        "unknown",
+			URL:         file.URL,
+			PreviewURL:  file.ThumbnailURL,
+			Description: file.Comment,
+			Blurhash:    file.Blurhash,
+			Meta: MediaMeta{
+				Original: MediaDimensions{
+					Width:  file.Properties.Width,
+					Height: file.Properties.Height,
+				},
+			},
+		}
+
+		switch mediaType {
+		case "image", "video", "audio":
+			media.Type = mediaType
+		}
+
+		post.Sensitive = post.Sensitive || file.IsSensitive
+		post.MediaAttachments = append(post.MediaAttachments, media)
+	}
+
+	for _, tag := range note.Tags {
+		post.Tags = append(post.Tags, Tag{
+			Name: tag,
+			URL:  fmt.Sprintf("%s/tags/%s", b.baseURL, tag),
+		})
+	}
+
+	for shortcode, emojiURL := range note.Emojis {
+		post.Emojis = append(post.Emojis, Emoji{
+			Shortcode: shortcode,
+			URL:       emojiURL,
+			StaticURL: emojiURL,
+		})
+	}
+
+	for name, count := range note.Reactions {
+		// Custom emoji are given as :name@host: or :name@.: for local ones.
+		shortcode := strings.TrimSuffix(strings.Trim(name, ":"), "@.")
+
+		post.Reactions = append(post.Reactions, Reaction{
+			Name:  shortcode,
+			Count: count,
+			URL:   note.ReactionEmojis[shortcode],
+		})
+		post.FavoritesCount += count
+	}
+
+	slices.SortFunc(post.Reactions, func(a, c Reaction) int {
+		if a.Count != c.Count {
+			return cmp.Compare(c.Count, a.Count)
+		}
+
+		return strings.Compare(a.Name, c.Name)
+	})
+
+	slices.SortFunc(post.Emojis, func(a, c Emoji) int {
+		return strings.Compare(a.Shortcode, c.Shortcode)
+	})
+
+	// Archived as a status, so that it can be rendered again like those of
+	// other servers.
+	status := misskeyStatus{
+		misskeyStatusFields: misskeyStatusFields(post),
+		ContentType:         post.ContentType,
+		Reactions:           post.Reactions,
+	}
+
+	if post.Reblog != nil {
+		status.Reblog = post.Reblog.raw
+	}
+
+	raw, err := json.Marshal(status)
+
+	if err != nil {
+		return post, fmt.Errorf("error archiving note %s: %w", note.Id, err)
+	}
+
+	post.raw = raw
+
+	return post, nil
+}
+
+func misskeyVisibility(visibility string) string {
+	switch visibility {
+	case "home":
+		return "unlisted"
+	case "followers":
+		return "private"
+	case "specified":
+		return "direct"
+	}
+
+	return "public"
+}
+
+// mfmToHTML renders MFM text as HTML paragraphs. MFM's markup is left as it
+// is, and is mostly compatible with Markdown.
+func mfmToHTML(text string) string {
+	if text == "" {
+		return ""
+	}
+
+	var paragraphs []string
+
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		lines := strings.Split(html.EscapeString(strings.TrimSpace(paragraph)), "\n")
+		paragraphs = append(paragraphs, fmt.Sprintf("<p>%s</p>", strings.Join(lines, "<br>")))
+	}
+
+	return strings.Join(paragraphs, "")
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Scopes requested by the login command, which only reads accounts and
+// their statuses.
+const LoginScopes = "read:accounts read:statuses"
+
+// Redirect URI that makes the instance show the authorization code, for the
+// user to paste it, instead of redirecting to an application.
+const OutOfBandRedirectURI = "urn:ietf:wg:oauth:2.0:oob"
+
+// Application as registered with an instance.
+type App struct {
+	ClientId     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RedirectURI  string `json:"redirect_uri"`
+}
+
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	Scope       string `json:"scope"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+// RegisterApp registers the program as an application with the instance at
+// baseURL, allowed to request scopes and to redirect to redirectURI.
+func RegisterApp(baseURL, redirectURI, scopes string) (App, error) {
+	var app App
+
+	body := map[string]string{
+		"client_name":   "mastodon-markdown-archive",
+		"redirect_uris": redirectURI,
+		"scopes":        scopes,
+		"website":       "https://git.garrido.io/gabriel/mastodon-markdown-archive",
+	}
+
+	if err := FetchJSON(baseURL+"/api/v1/apps", body, &app, map[string]string{}); err != nil {
+		return app, err
+	}
+
+	if app.RedirectURI == "" {
+		app.RedirectURI = redirectURI
+	}
+
+	return app, nil
+}
+
+// AuthorizeURL returns the page where the user grants app the scopes. The
+// instance redirects back to the app's redirect URI with the authorization
+// code and state.
+func AuthorizeURL(baseURL string, app App, scopes, state string) string {
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {app.ClientId},
+		"redirect_uri":  {app.RedirectURI},
+		"scope":         {scopes},
+	}
+
+	if state != "" {
+		query.Set("state", state)
+	}
+
+	return fmt.Sprintf("%s/oauth/authorize?%s", baseURL, query.Encode())
+}
+
+// RequestToken exchanges an authorization code for an access token.
+func RequestToken(baseURL string, app App, code, scopes string) (Token, error) {
+	var token Token
+
+	body := map[string]string{
+		"grant_type":    "authorization_code",
+		"code":          code,
+		"client_id":     app.ClientId,
+		"client_secret": app.ClientSecret,
+		"redirect_uri":  app.RedirectURI,
+		"scope":         scopes,
+	}
+
+	if err := FetchJSON(baseURL+"/oauth/token", body, &token, map[string]string{}); err != nil {
+		return token, err
+	}
+
+	if token.AccessToken == "" {
+		return token, fmt.Errorf("no access token in the response of %s/oauth/token", baseURL)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"slices"
+)
+
+// Load builds a client from statuses that were archived earlier, without
+// any network access. The account's posts among statuses are processed as
+// if they had been fetched, and threads are rebuilt from the other statuses
+// instead of the status context endpoint.
+func Load(account Account, statuses []Post, opts ClientOptions) (Client, error) {
+	backend := &localBackend{
+		account:  account,
+		statuses: make(map[string]Post),
+	}
+
+	for _, status := range statuses {
+		backend.statuses[status.Id] = status
+	}
+
+	client := Client{
+		account:   account,
+		postIdMap: make(map[string]*Post),
+		replies:   make(map[string]string),
+		options:   opts,
+		backend:   backend,
+	}
+
+	page, err := backend.Posts(PostsFilter{})
+
+	if err != nil {
+		return client, err
+	}
+
+	if err := client.ingest(page.Posts); err != nil {
+		return client, err
+	}
+
+	return client, nil
+}
+
+// localBackend reads posts from statuses that were archived earlier.
+type localBackend struct {
+	account Account
+	// Map of Post.Id:Post, including the statuses of other accounts.
+	statuses map[string]Post
+}
+
+func (b *localBackend) Account() Account {
+	return b.account
+}
+
+// Posts returns every post of the account, ignoring filters since archived
+// posts were filtered when they were fetched.
+func (b *localBackend) Posts(filters PostsFilter) (Page, error) {
+	var posts []Post
+
+	for _, status := range b.statuses {
+		if status.Account.Id == b.account.Id {
+			posts = append(posts, status)
+		}
+	}
+
+	// The API returns the newest posts first.
+	slices.SortFunc(posts, func(a, b Post) int {
+		return CompareIds(b.Id, a.Id)
+	})
+
+	return newPage(posts), nil
+}
+
+func (b *localBackend) Context(postId string) (StatusContext, error) {
+	return localContext(b.statuses, postId), nil
+}
+
+// localContext mirrors the status context endpoint: the ancestors of a
+// status, oldest first, and every status in the replies below it.
+func localContext(statuses map[string]Post, postId string) StatusContext {
+	var statusContext StatusContext
+	// A server, or an archive edited by hand, could make replies form a
+	// cycle, which is only walked once around.
+	visited := map[string]bool{postId: true}
+
+	for parentId := statuses[postId].InReplyToId; parentId != "" && !visited[parentId]; {
+		parent, ok := statuses[parentId]
+
+		if !ok {
+			break
+		}
+
+		visited[parentId] = true
+
+		statusContext.Ancestors = append([]Post{parent}, statusContext.Ancestors...)
+		parentId = parent.InReplyToId
+	}
+
+	for _, status := range statuses {
+		visited := map[string]bool{status.Id: true}
+
+		for parentId := status.InReplyToId; parentId != "" && !visited[parentId]; parentId = statuses[parentId].InReplyToId {
+			visited[parentId] = true
+
+			if parentId == postId {
+				statusContext.Descendants = append(statusContext.Descendants, status)
+				break
+			}
+
+			if _, ok := statuses[parentId]; !ok {
+				break
+			}
+		}
+	}
+
+	slices.SortFunc(statusContext.Descendants, func(a, b Post) int {
+		return CompareIds(a.Id, b.Id)
+	})
+
+	return statusContext
+}
@@ -0,0 +1,233 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Media type of ActivityPub documents.
+const activityJSON = "application/activity+json"
+
+// FetchActor retrieves an actor's document.
+func FetchActor(actorURL string) (Actor, error) {
+	var actor Actor
+	headers := map[string]string{"Accept": activityJSON}
+
+	if err := Fetch(actorURL, &actor, headers); err != nil {
+		return actor, err
+	}
+
+	return actor, nil
+}
+
+// FetchOutboxPage retrieves an actor's outbox, or one of its pages.
+func FetchOutboxPage(pageURL string) (OrderedCollection, error) {
+	var page OrderedCollection
+	headers := map[string]string{"Accept": activityJSON}
+
+	if err := Fetch(pageURL, &page, headers); err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
+
+// resolveActor finds the document of the actor behind a profile. Servers
+// whose WebFinger did not point to the actor are asked for the profile URL
+// itself as an ActivityPub document.
+func resolveActor(location UserLocation, user string) (Actor, error) {
+	actorURL := location.ActorURL
+
+	if actorURL == "" && !strings.Contains(user, "://") {
+		return Actor{}, fmt.Errorf("could not find the actor of %s with WebFinger", user)
+	}
+
+	if actorURL == "" {
+		actorURL = user
+	}
+
+	actor, err := FetchActor(actorURL)
+
+	if err != nil {
+		return actor, fmt.Errorf("error fetching actor: %w", err)
+	}
+
+	if actor.Outbox == "" {
+		return actor, fmt.Errorf("actor %s has no outbox", actor.Id)
+	}
+
+	return actor, nil
+}
+
+// outbox reads an actor's posts from its outbox, newest first, and keeps
+// the posts it read so that later batches and threads don't fetch them again.
+type outbox struct {
+	actor Actor
+	posts []Post
+	// URL of the next page to read. Empty before the first page is read.
+	next string
+	done bool
+}
+
+// load reads pages until more than n posts were read or the outbox ends.
+func (o *outbox) load(n int) error {
+	for !o.done && len(o.posts) <= n {
+		pageURL := o.next
+
+		if pageURL == "" {
+			collection, err := FetchOutboxPage(o.actor.Outbox)
+
+			if err != nil {
+				return err
+			}
+
+			if collection.First == "" {
+				o.done = true
+				o.appendItems(collection.OrderedItems)
+				return nil
+			}
+
+			pageURL = string(collection.First)
+		}
+
+		page, err := FetchOutboxPage(pageURL)
+
+		if err != nil {
+			return err
+		}
+
+		o.appendItems(page.OrderedItems)
+		o.next = string(page.Next)
+
+		if len(page.OrderedItems) == 0 || o.next == "" || o.next == pageURL {
+			o.done = true
+		}
+	}
+
+	return nil
+}
+
+func (o *outbox) Account() Account {
+	return o.actor.Account()
+}
+
+func (o *outbox) appendItems(activities []Activity) {
+	for _, activity := range activities {
+		if post, ok, err := activity.Post(o.actor); err == nil && ok {
+			o.posts = append(o.posts, post)
+		}
+	}
+}
+
+// indexOf reads the outbox until it finds the post with postId.
+func (o *outbox) indexOf(postId string) (int, error) {
+	for i := 0; ; i++ {
+		if err := o.load(i); err != nil {
+			return -1, err
+		}
+
+		if i >= len(o.posts) {
+			return -1, nil
+		}
+
+		if o.posts[i].Id == postId {
+			return i, nil
+		}
+	}
+}
+
+// Posts returns a batch of posts as the statuses endpoint would, applying
+// its filters and cursors to the posts in the outbox.
+func (o *outbox) Posts(filters PostsFilter) (Page, error) {
+	if filters.Pinned {
+		return Page{}, fmt.Errorf("pinned posts cannot be read from an outbox")
+	}
+
+	limit := filters.Limit
+
+	// The statuses endpoint's default.
+	if limit <= 0 {
+		limit = 20
+	}
+
+	start := 0
+
+	if filters.MaxId != "" {
+		index, err := o.indexOf(filters.MaxId)
+
+		if err != nil || index == -1 {
+			return Page{}, err
+		}
+
+		start = index + 1
+	}
+
+	// Without a lower cursor, only as many posts as the batch needs are read.
+	end := -1
+	lowerId := filters.MinId
+
+	if lowerId == "" {
+		lowerId = filters.SinceId
+	}
+
+	if lowerId != "" {
+		index, err := o.indexOf(lowerId)
+
+		if err != nil {
+			return Page{}, err
+		}
+
+		// A cursor that is not in the outbox is older than every post.
+		end = index
+
+		if index == -1 {
+			end = len(o.posts)
+		}
+	}
+
+	account := o.actor.Account()
+	var posts []Post
+
+	for i := start; end == -1 || i < end; i++ {
+		if err := o.load(i); err != nil {
+			return Page{}, err
+		}
+
+		if i >= len(o.posts) {
+			break
+		}
+
+		post := o.posts[i]
+
+		if !filters.Matches(post, account.Id) {
+			continue
+		}
+
+		posts = append(posts, post)
+
+		if end == -1 && len(posts) == limit {
+			break
+		}
+	}
+
+	// A min_id cursor pages forward, so the batch is made of the posts
+	// right after it.
+	if filters.MinId != "" && len(posts) > limit {
+		posts = posts[len(posts)-limit:]
+	} else if len(posts) > limit {
+		posts = posts[:limit]
+	}
+
+	return newPage(posts), nil
+}
+
+// Context rebuilds the context of a post from the posts read so far.
+func (o *outbox) Context(postId string) (StatusContext, error) {
+	statuses := make(map[string]Post)
+
+	for _, post := range o.posts {
+		statuses[post.Id] = post
+	}
+
+	return localContext(statuses, postId), nil
+}
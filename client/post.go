@@ -1,10 +1,12 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
-	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,11 +16,88 @@ type StatusContext struct {
 }
 
 type MediaAttachment struct {
-	Type        string `json:"type"`
-	URL         string `json:"url"`
-	Description string `json:"description"`
-	Id          string `json:"id"`
+	Type        string    `json:"type"`
+	URL         string    `json:"url"`
+	RemoteURL   string    `json:"remote_url"`
+	PreviewURL  string    `json:"preview_url"`
+	Description string    `json:"description"`
+	Id          string    `json:"id"`
+	Meta        MediaMeta `json:"meta"`
+	Blurhash    string    `json:"blurhash"`
 	Path        string
+	// Hex encoded SHA-256 hash of the downloaded file.
+	Hash string
+	// Which of URL, RemoteURL or PreviewURL the downloaded file came from,
+	// as "url", "remote_url" or "preview_url".
+	Source string
+	// Resized copies of the downloaded image, from smallest to largest,
+	// ending with the downloaded image itself.
+	Variants []MediaVariant
+	// Path of an image generated from the media's blurhash, to be shown
+	// while the media loads.
+	PlaceholderPath string
+}
+
+type MediaVariant struct {
+	Width  int
+	Height int
+	Path   string
+}
+
+type MediaMeta struct {
+	Original MediaDimensions `json:"original"`
+	Small    MediaDimensions `json:"small"`
+	Focus    MediaFocus      `json:"focus"`
+	// Duration of audio and video, formatted as H:MM:SS.ss
+	Length   string  `json:"length"`
+	Duration float64 `json:"duration"`
+}
+
+type MediaDimensions struct {
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	Size      string  `json:"size"`
+	Aspect    float64 `json:"aspect"`
+	Duration  float64 `json:"duration"`
+	FrameRate string  `json:"frame_rate"`
+	Bitrate   int     `json:"bitrate"`
+}
+
+// Focal point of an image, from -1.0 to 1.0 on each axis with the center
+// at 0,0.
+type MediaFocus struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Emoji is a custom emoji used in a post.
+type Emoji struct {
+	Shortcode string `json:"shortcode"`
+	URL       string `json:"url"`
+	StaticURL string `json:"static_url"`
+}
+
+type Reaction struct {
+	// Either a unicode emoji, or the shortcode of a custom emoji.
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	// Image of a custom emoji.
+	URL string `json:"url"`
+}
+
+// postExtensions holds the fields that servers other than Mastodon add to
+// statuses.
+type postExtensions struct {
+	ContentType string     `json:"content_type"`
+	Reactions   []Reaction `json:"reactions"`
+	Pleroma     struct {
+		EmojiReactions []Reaction `json:"emoji_reactions"`
+	} `json:"pleroma"`
+	Akkoma struct {
+		Source struct {
+			MediaType string `json:"mediaType"`
+		} `json:"source"`
+	} `json:"akkoma"`
 }
 
 type Application struct {
@@ -55,7 +134,20 @@ type Post struct {
 	Muted              bool              `json:"muted"`
 	Bookmarked         bool              `json:"bookmarked"`
 	Account            Account           `json:"account"`
-	descendants        []*Post
+	Reblog             *Post             `json:"reblog"`
+	Emojis             []Emoji           `json:"emojis"`
+	// Emoji reactions, on servers which support them such as Pleroma and
+	// Akkoma.
+	Reactions []Reaction `json:"-"`
+	// Media type of the text the post was written in, such as
+	// text/markdown, on servers which let posts be written in other formats
+	// than plain text.
+	ContentType string `json:"-"`
+	// Source of the post as it was written, on servers which return it
+	// such as Misskey.
+	Text        string `json:"text"`
+	descendants []*Post
+	raw         json.RawMessage
 }
 
 type PostsFilter struct {
@@ -70,6 +162,38 @@ type PostsFilter struct {
 	Tagged         string
 }
 
+// Matches reports whether the post passes the filters that the statuses
+// endpoint applies, for posts that were not fetched through it. accountId is
+// that of the account whose posts are filtered.
+func (f PostsFilter) Matches(post Post, accountId string) bool {
+	if f.ExcludeReblogs && post.Reblog != nil {
+		return false
+	}
+
+	if f.ExcludeReplies && post.InReplyToId != "" && post.InReplyToAccountId != accountId {
+		return false
+	}
+
+	if f.OnlyMedia && len(post.MediaAttachments) == 0 {
+		return false
+	}
+
+	if f.Tagged != "" && !slices.ContainsFunc(post.Tags, func(tag Tag) bool {
+		return strings.EqualFold(tag.Name, f.Tagged)
+	}) {
+		return false
+	}
+
+	return true
+}
+
+// Filtered reports whether the filters leave out some of the account's
+// posts, so that the posts fetched with them leave gaps between their
+// bounds.
+func (f PostsFilter) Filtered() bool {
+	return f.ExcludeReplies || f.ExcludeReblogs || f.OnlyMedia || f.Pinned || f.Tagged != ""
+}
+
 func (p Post) ShouldSkip(visibility string) bool {
 	if visibility == "" {
 		return false
@@ -162,7 +286,7 @@ func FetchPosts(baseURL string, accountId string, filters PostsFilter) ([]Post,
 		accountId,
 		query,
 	)
-	setAuthTokenIfPassed(&headers)
+	setAuthTokenIfPassed(&headers, postsUrl)
 
 
 	if err := Fetch(postsUrl, &posts, headers); err != nil {
@@ -182,7 +306,7 @@ func FetchStatusContext(baseURL, postId string) (StatusContext, error) {
 		postId,
 	)
 
-	setAuthTokenIfPassed(&headers)
+	setAuthTokenIfPassed(&headers, statusUrl)
 
 	if err := Fetch(statusUrl, &status, headers); err != nil {
 		return status, err
@@ -191,9 +315,85 @@ func FetchStatusContext(baseURL, postId string) (StatusContext, error) {
 	return status, nil
 }
 
-func setAuthTokenIfPassed(headers *map[string]string) {
-	token, ok := os.LookupEnv("MASTODON_AUTH_TOKEN")
-	if ok {
-		(*headers)["Authorization"] = fmt.Sprintf("Bearer %s", token)
+func FetchStatus(baseURL, postId string) (Post, error) {
+	var post Post
+	headers := make(map[string]string)
+
+	statusUrl := fmt.Sprintf(
+		"%s/api/v1/statuses/%s",
+		baseURL,
+		postId,
+	)
+
+	setAuthTokenIfPassed(&headers, statusUrl)
+
+	if err := Fetch(statusUrl, &post, headers); err != nil {
+		return post, err
+	}
+
+	return post, nil
+}
+
+// FetchStatuses retrieves several statuses in a single request. Statuses that
+// do not exist, or that are not visible, are left out of the response. Only
+// supported since Mastodon 4.3.
+func FetchStatuses(baseURL string, postIds []string) ([]Post, error) {
+	var posts []Post
+	headers := make(map[string]string)
+
+	queryValues := url.Values{}
+
+	for _, postId := range postIds {
+		queryValues.Add("id[]", postId)
+	}
+
+	statusesUrl := fmt.Sprintf(
+		"%s/api/v1/statuses?%s",
+		baseURL,
+		queryValues.Encode(),
+	)
+
+	setAuthTokenIfPassed(&headers, statusesUrl)
+
+	if err := Fetch(statusesUrl, &posts, headers); err != nil {
+		return posts, err
+	}
+
+	return posts, nil
+}
+
+// UnmarshalJSON decodes a status and keeps a copy of the JSON it was decoded
+// from, so that the response can be archived as is.
+func (p *Post) UnmarshalJSON(data []byte) error {
+	type post Post
+
+	if err := json.Unmarshal(data, (*post)(p)); err != nil {
+		return err
+	}
+
+	var extensions postExtensions
+
+	if err := json.Unmarshal(data, &extensions); err != nil {
+		return err
 	}
+
+	p.ContentType = extensions.ContentType
+	p.Reactions = extensions.Reactions
+
+	if extensions.Akkoma.Source.MediaType != "" {
+		p.ContentType = extensions.Akkoma.Source.MediaType
+	}
+
+	if len(extensions.Pleroma.EmojiReactions) > 0 {
+		p.Reactions = extensions.Pleroma.EmojiReactions
+	}
+
+	p.raw = append(json.RawMessage(nil), data...)
+
+	return nil
+}
+
+// Raw returns the JSON of the status as the API returned it.
+func (p Post) Raw() json.RawMessage {
+	return p.raw
 }
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Server software that implements Mastodon's API, with its own quirks.
+const (
+	SoftwareMastodon   = "mastodon"
+	SoftwarePleroma    = "pleroma"
+	SoftwareAkkoma     = "akkoma"
+	SoftwareGoToSocial = "gotosocial"
+)
+
+// Software describes the server software an account lives on.
+type Software struct {
+	// Lowercase name of the software, such as SoftwareMastodon.
+	Name    string
+	Version string
+}
+
+type nodeInfoLinks struct {
+	Links []WebFingerLink `json:"links"`
+}
+
+type nodeInfo struct {
+	Software struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"software"`
+}
+
+type instance struct {
+	Version string `json:"version"`
+}
+
+// Pleroma and Akkoma report a Mastodon version followed by their own, such
+// as "2.7.2 (compatible; Pleroma 2.5.0)".
+var compatibleVersion = regexp.MustCompile(`\(compatible; (\w+) ([^)]+)\)`)
+
+// DetectSoftware finds out which software runs the server at baseURL, from
+// its NodeInfo or, failing that, from the version of its instance. Servers
+// that cannot be told apart are assumed to run Mastodon.
+func DetectSoftware(baseURL string) Software {
+	var links nodeInfoLinks
+
+	if err := Fetch(baseURL+"/.well-known/nodeinfo", &links, map[string]string{}); err == nil {
+		for _, link := range links.Links {
+			if !strings.HasPrefix(link.Rel, "http://nodeinfo.diaspora.software/ns/schema/") {
+				continue
+			}
+
+			var info nodeInfo
+
+			if err := Fetch(link.Href, &info, map[string]string{}); err == nil && info.Software.Name != "" {
+				return Software{
+					Name:    strings.ToLower(info.Software.Name),
+					Version: info.Software.Version,
+				}
+			}
+		}
+	}
+
+	var server instance
+
+	if err := Fetch(baseURL+"/api/v1/instance", &server, map[string]string{}); err == nil {
+		if match := compatibleVersion.FindStringSubmatch(server.Version); match != nil {
+			return Software{
+				Name:    strings.ToLower(match[1]),
+				Version: match[2],
+			}
+		}
+
+		return Software{
+			Name:    SoftwareMastodon,
+			Version: server.Version,
+		}
+	}
+
+	return Software{Name: SoftwareMastodon}
+}
+
+// MaxLimit is the largest batch of statuses the software returns at once.
+// Mastodon, Pleroma and Akkoma lower larger limits to 40.
+func (s Software) MaxLimit() int {
+	if s.Name == SoftwareGoToSocial {
+		return 80
+	}
+
+	return 40
+}
+
+// BatchesStatuses reports whether the software can return several statuses
+// in one request through /api/v1/statuses.
+func (s Software) BatchesStatuses() bool {
+	return s.Name == SoftwareMastodon
+}
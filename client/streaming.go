@@ -0,0 +1,192 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Events of the streaming API that concern an account's statuses.
+const (
+	// A status was published.
+	StreamUpdate = "update"
+	// A status was edited.
+	StreamStatusUpdate = "status.update"
+	// A status was deleted.
+	StreamDelete = "delete"
+)
+
+// StreamEvent is an event received from the streaming API.
+type StreamEvent struct {
+	Event string
+	// Status published or edited by update and status.update events.
+	Status Post
+	// Id of the status removed by a delete event.
+	StatusId string
+}
+
+type instanceURLs struct {
+	URLs struct {
+		StreamingAPI string `json:"streaming_api"`
+	} `json:"urls"`
+}
+
+// StreamingURL returns the URL the streaming API of the instance at baseURL
+// is served from, which can be on a different host than the instance.
+func StreamingURL(baseURL string) string {
+	var server instanceURLs
+
+	if err := Fetch(baseURL+"/api/v1/instance", &server, map[string]string{}); err != nil || server.URLs.StreamingAPI == "" {
+		return baseURL
+	}
+
+	streamingURL := strings.TrimSuffix(server.URLs.StreamingAPI, "/")
+	streamingURL = strings.Replace(streamingURL, "wss://", "https://", 1)
+	streamingURL = strings.Replace(streamingURL, "ws://", "http://", 1)
+
+	return streamingURL
+}
+
+// UserStream is the user stream of an account's instance. It holds its own
+// copy of what it needs from the client, so that it can be read while the
+// client fetches posts.
+type UserStream struct {
+	baseURL   string
+	accountId string
+}
+
+// UserStream returns the user stream of the account's instance. Posts can
+// only be watched through the Mastodon API.
+func (c *Client) UserStream() (UserStream, error) {
+	if _, ok := c.backend.(*mastodonBackend); !ok {
+		return UserStream{}, errors.New("posts can only be watched through the Mastodon API")
+	}
+
+	return UserStream{
+		baseURL:   c.baseURL,
+		accountId: c.account.Id,
+	}, nil
+}
+
+// Stream connects to the user stream through server-sent events, and sends
+// the events that concern the account's statuses on events. It returns when
+// ctx is done or the connection drops. The stream is authorized with the
+// instance's token, which is required.
+func (s UserStream) Stream(ctx context.Context, events chan<- StreamEvent) error {
+	headers := make(map[string]string)
+	setAuthTokenIfPassed(&headers, s.baseURL)
+
+	if headers["Authorization"] == "" {
+		return fmt.Errorf("watching posts needs a token for %s, log in first", s.baseURL)
+	}
+
+	// The token is sent to the streaming API that the instance itself
+	// points to.
+	streamURL := StreamingURL(s.baseURL) + "/api/v1/streaming/user"
+	req, err := http.NewRequestWithContext(ctx, "GET", streamURL, nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return ResponseError{
+			URL:        streamURL,
+			StatusCode: res.StatusCode,
+			Status:     res.Status,
+		}
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var event string
+	var data []string
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// A blank line ends an event. Lines starting with a colon are
+		// comments, which the server sends to keep the connection alive.
+		if line == "" {
+			if event != "" {
+				if err := s.dispatch(ctx, event, strings.Join(data, "\n"), events); err != nil {
+					return err
+				}
+			}
+
+			event = ""
+			data = nil
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return errors.New("the stream was closed by the server")
+}
+
+// dispatch decodes an event, and sends it on events if it concerns a status
+// of the account.
+func (s UserStream) dispatch(ctx context.Context, event, data string, events chan<- StreamEvent) error {
+	streamEvent := StreamEvent{Event: event}
+
+	switch event {
+	case StreamUpdate, StreamStatusUpdate:
+		if err := json.Unmarshal([]byte(data), &streamEvent.Status); err != nil {
+			return fmt.Errorf("error decoding %s event: %w", event, err)
+		}
+
+		// The user stream also carries the statuses of followed accounts.
+		if streamEvent.Status.Account.Id != s.accountId {
+			return nil
+		}
+	case StreamDelete:
+		// The deleted status may belong to any account, which is left to
+		// the receiver to tell from what it archived.
+		streamEvent.StatusId = strings.Trim(data, `"`)
+	default:
+		return nil
+	}
+
+	select {
+	case events <- streamEvent:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
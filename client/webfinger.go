@@ -0,0 +1,122 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// WebFinger is the description of a resource returned by a server's
+// WebFinger endpoint.
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type"`
+	Href string `json:"href"`
+}
+
+// FetchWebFinger looks up resource, such as acct:user@domain, on the server
+// at baseURL.
+func FetchWebFinger(baseURL, resource string) (WebFinger, error) {
+	var webFinger WebFinger
+
+	webFingerUrl := fmt.Sprintf(
+		"%s/.well-known/webfinger?%s",
+		baseURL,
+		url.Values{"resource": {resource}}.Encode(),
+	)
+
+	headers := map[string]string{
+		"Accept": "application/jrd+json, application/json",
+	}
+
+	if err := Fetch(webFingerUrl, &webFinger, headers); err != nil {
+		return webFinger, err
+	}
+
+	return webFinger, nil
+}
+
+// Link returns the href of the first link with the given relation and, when
+// mediaType is not empty, the given type.
+func (w WebFinger) Link(rel, mediaType string) string {
+	for _, link := range w.Links {
+		if link.Rel == rel && (mediaType == "" || link.Type == mediaType) {
+			return link.Href
+		}
+	}
+
+	return ""
+}
+
+// UserLocation is where an account lives, as found from the --user option.
+type UserLocation struct {
+	// Scheme and host of the server running the account's API.
+	BaseURL string
+	// Handle to look the account up with on that server, either username
+	// or username@domain.
+	Handle string
+	// URL of the account's ActivityPub actor, when WebFinger returned it.
+	ActorURL string
+}
+
+// ResolveUser finds the server and handle of an account given either as a
+// profile URL, as @username@domain, or as acct:username@domain.
+//
+// The account is looked up with WebFinger, which also covers accounts whose
+// handle is on a different domain than their server, and profile URLs of
+// other shapes than Mastodon's. Without WebFinger, the server is the host
+// of the profile URL or the domain of the handle.
+func ResolveUser(user string) (UserLocation, error) {
+	var location UserLocation
+	var resource string
+
+	if strings.Contains(user, "://") {
+		parsedURL, err := url.Parse(user)
+
+		if err != nil {
+			return location, fmt.Errorf("error parsing user url: %w", err)
+		}
+
+		path := strings.TrimSuffix(parsedURL.Path, "/")
+		location.BaseURL = fmt.Sprintf("%s://%s", parsedURL.Scheme, parsedURL.Host)
+		location.Handle = strings.TrimPrefix(path[strings.LastIndex(path, "/")+1:], "@")
+		resource = user
+	} else {
+		acct := strings.TrimPrefix(strings.TrimPrefix(user, "acct:"), "@")
+		username, domain, ok := strings.Cut(acct, "@")
+
+		if !ok || username == "" || domain == "" {
+			return location, fmt.Errorf("invalid user %q, expected a profile URL, @username@domain, or acct:username@domain", user)
+		}
+
+		location.BaseURL = fmt.Sprintf("https://%s", domain)
+		location.Handle = acct
+		resource = fmt.Sprintf("acct:%s", acct)
+	}
+
+	webFinger, err := FetchWebFinger(location.BaseURL, resource)
+
+	if err != nil {
+		return location, nil
+	}
+
+	if acct, ok := strings.CutPrefix(webFinger.Subject, "acct:"); ok {
+		location.Handle = acct
+	}
+
+	if actorURL := webFinger.Link("self", activityJSON); actorURL != "" {
+		location.ActorURL = actorURL
+
+		if parsedURL, err := url.Parse(actorURL); err == nil && parsedURL.Host != "" {
+			location.BaseURL = fmt.Sprintf("%s://%s", parsedURL.Scheme, parsedURL.Host)
+		}
+	}
+
+	return location, nil
+}
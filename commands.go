@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/config"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/credentials"
+)
+
+// Name of the program, as it is shown in usage.
+const programName = "mastodon-markdown-archive"
+
+// Command that is run when none is given, with every argument, as the
+// program did before it had commands.
+const defaultCommand = "fetch"
+
+// command is run with the arguments that follow its name.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"fetch", "Fetch a batch of the account's posts and write them to files", fetch},
+		{"sync", "Fetch every post newer and older than those already archived", syncPosts},
+		{"render", "Write the archived posts again from their raw statuses, without any network access", render},
+		{"import", "Write the posts of an account archive requested from Mastodon's settings", importArchive},
+		{"reconcile", "Find the archived posts that were deleted upstream, and handle their files", reconcile},
+		{"watch", "Archive posts as they are published, edited, and deleted, through the streaming API", watch},
+		{"daemon", "Sync one or more accounts on an interval", daemon},
+		{"login", "Obtain an access token for an instance and store it", login},
+		{"stats", "Show what has been archived in a directory", stats},
+		{"verify", "Check that the archived files are in place and unchanged", verify},
+		{"serve", "Serve the archive over HTTP, to preview it", serve},
+		{"help", "Show the commands, or the options of a command", help},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+
+	return command{}, false
+}
+
+// runCommand runs the command named by the first argument, or the default
+// command with every argument when the first one is a flag or there are none.
+func runCommand(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fetch(args)
+		return
+	}
+
+	c, ok := findCommand(args[0])
+
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		printCommands()
+		os.Exit(2)
+	}
+
+	c.run(args[1:])
+}
+
+// globalOptions are the options every command takes.
+type globalOptions struct {
+	credentials *string
+	config      *string
+	profile     *string
+}
+
+// newFlagSet returns the flag set of a command, with the global options
+// already defined. Its usage shows the command's summary along with its
+// options.
+func newFlagSet(name string) (*flag.FlagSet, globalOptions) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+
+	flags.Usage = func() {
+		c, _ := findCommand(name)
+		out := flags.Output()
+
+		fmt.Fprintf(out, "Usage: %s %s [options]\n\n%s.\n\nOptions:\n", programName, name, c.summary)
+		flags.PrintDefaults()
+		fmt.Fprintf(out, "\nRun %s help to list every command.\n", programName)
+	}
+
+	globals := globalOptions{
+		credentials: flags.String("credentials", credentials.DefaultPath(), "Path to the file where the login command stores access tokens, which are sent to the instances they belong to"),
+		config:      flags.String("config", config.DefaultPath(), "Path to a TOML file of options for named profiles, which flags and MASTODON_ARCHIVE_ environment variables override"),
+		profile:     flags.String("profile", "", "Name of the profile in --config whose options are used, or all to run every profile in turn. Omit to only use the file's defaults"),
+	}
+
+	return flags, globals
+}
+
+// parse parses a command's args, fills the options that were not passed
+// from the environment and the configuration file, and loads the stored
+// credentials. With --profile=all, it runs the command once for each
+// profile instead, and returns false.
+func (g globalOptions) parse(flags *flag.FlagSet, args []string) bool {
+	if err := checkFlags(flags); err != nil {
+		log.Panicln(err)
+	}
+
+	flags.Parse(args)
+
+	if err := configure(flags); err != nil {
+		log.Panicln(err)
+	}
+
+	if *g.profile == config.AllProfiles {
+		c, _ := findCommand(flags.Name())
+		runProfiles(args, *g.config, c.run)
+		return false
+	}
+
+	useCredentials(*g.credentials)
+
+	return true
+}
+
+// help shows the commands, or the usage of the command it is given.
+func help(args []string) {
+	if len(args) == 0 {
+		printCommands()
+		return
+	}
+
+	c, ok := findCommand(args[0])
+
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		printCommands()
+		os.Exit(2)
+	}
+
+	if c.name == "help" {
+		printCommands()
+		return
+	}
+
+	// Commands print their usage and exit when asked for help.
+	c.run([]string{"-h"})
+}
+
+func printCommands() {
+	out := os.Stderr
+
+	fmt.Fprintf(out, "Usage: %s [command] [options]\n\nCommands:\n", programName)
+
+	for _, c := range commands {
+		name := c.name
+
+		if name == defaultCommand {
+			name += " (default)"
+		}
+
+		fmt.Fprintf(out, "  %-18s%s\n", name, c.summary)
+	}
+
+	fmt.Fprint(out, `
+Every command takes these options:
+  -config string
+        Path to a TOML file of options for named profiles
+  -credentials string
+        Path to the file where the login command stores access tokens
+  -profile string
+        Name of the profile in --config whose options are used, or all
+
+Options can also be set with MASTODON_ARCHIVE_ environment variables, such
+as MASTODON_ARCHIVE_DIST for --dist.
+
+`)
+	fmt.Fprintf(out, "Run %s help <command> to show the options of a command.\n", programName)
+}
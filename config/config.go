@@ -0,0 +1,134 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Name of the configuration file, kept in the user's configuration
+// directory.
+const Filename = "config.toml"
+
+// Name that selects every profile at once.
+const AllProfiles = "all"
+
+// Config holds named profiles of options, each of them an account archived
+// in its own way. Options are named after the command's flags, such as
+// dist or exclude-replies.
+type Config struct {
+	// Options shared by every profile, which a profile can override.
+	Defaults Options `toml:"defaults"`
+	// Map of profile name:Options.
+	Profiles map[string]Options `toml:"profiles"`
+	path     string
+}
+
+// Options maps flag names to their values.
+type Options map[string]interface{}
+
+// DefaultPath returns the path of the configuration file in the user's
+// configuration directory, such as ~/.config/mastodon-markdown-archive on
+// Linux.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+
+	if err != nil {
+		return Filename
+	}
+
+	return filepath.Join(dir, "mastodon-markdown-archive", Filename)
+}
+
+// Load reads the configuration file at path. A missing file yields an empty
+// configuration unless required is set.
+func Load(path string, required bool) (*Config, error) {
+	config := &Config{
+		Defaults: make(Options),
+		Profiles: make(map[string]Options),
+		path:     path,
+	}
+
+	meta, err := toml.DecodeFile(path, config)
+
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return config, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading configuration file: %w", err)
+	}
+
+	// Options outside of the defaults and the profiles would be ignored.
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("unknown key %s in %s, options are set in [defaults] or [profiles.<name>]", undecoded[0], path)
+	}
+
+	if _, ok := config.Profiles[AllProfiles]; ok {
+		return nil, fmt.Errorf("%s cannot be the name of a profile in %s", AllProfiles, path)
+	}
+
+	return config, nil
+}
+
+// Names returns the names of the profiles, in alphabetical order.
+func (c Config) Names() []string {
+	var names []string
+
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// Options returns the options of a profile, on top of the defaults. An
+// empty name yields the defaults alone.
+func (c Config) Options(name string) (Options, error) {
+	options := make(Options)
+
+	for key, value := range c.Defaults {
+		options[key] = value
+	}
+
+	if name == "" {
+		return options, nil
+	}
+
+	profile, ok := c.Profiles[name]
+
+	if !ok {
+		return nil, fmt.Errorf("no profile named %q in %s", name, c.path)
+	}
+
+	for key, value := range profile {
+		options[key] = value
+	}
+
+	return options, nil
+}
+
+// String formats an option's value as it would be passed as a flag. Lists
+// are joined with commas.
+func String(value interface{}) string {
+	list, ok := value.([]interface{})
+
+	if !ok {
+		return fmt.Sprint(value)
+	}
+
+	var values []string
+
+	for _, item := range list {
+		values = append(values, fmt.Sprint(item))
+	}
+
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,129 @@
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Name of the credentials file, kept in the user's configuration directory.
+const Filename = "credentials.json"
+
+// Store holds the access tokens obtained with the login command, one for
+// each instance. It is written with permissions that only let the user read
+// it, since the tokens grant access to the accounts.
+type Store struct {
+	// Map of host:Credential, where the host is that of the instance's URL,
+	// including its port when it is not the default.
+	Instances map[string]Credential `json:"instances"`
+	path      string
+}
+
+type Credential struct {
+	// URL of the instance the token was obtained from.
+	Server       string    `json:"server"`
+	ClientId     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret"`
+	AccessToken  string    `json:"access_token"`
+	Scope        string    `json:"scope"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// DefaultPath returns the path of the credentials file in the user's
+// configuration directory, such as ~/.config/mastodon-markdown-archive on
+// Linux.
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+
+	if err != nil {
+		return Filename
+	}
+
+	return filepath.Join(dir, "mastodon-markdown-archive", Filename)
+}
+
+// Load reads the credentials file at path. A missing file yields an empty
+// store.
+func Load(path string) (*Store, error) {
+	store := &Store{
+		Instances: make(map[string]Credential),
+		path:      path,
+	}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("error reading credentials file: %w", err)
+	}
+
+	if store.Instances == nil {
+		store.Instances = make(map[string]Credential)
+	}
+
+	return store, nil
+}
+
+// Save writes the store, readable and writable by the user alone.
+func (s *Store) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return err
+	}
+
+	// WriteFile keeps the permissions of a file that already exists.
+	return os.Chmod(s.path, 0600)
+}
+
+// Set stores the credential of the instance at its Server URL.
+func (s *Store) Set(credential Credential) error {
+	host, err := Host(credential.Server)
+
+	if err != nil {
+		return err
+	}
+
+	s.Instances[host] = credential
+
+	return nil
+}
+
+// Path returns the path of the credentials file.
+func (s Store) Path() string {
+	return s.path
+}
+
+// Host returns the host of rawURL, which credentials are keyed by.
+func Host(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+
+	if err != nil {
+		return "", err
+	}
+
+	if parsed.Host == "" {
+		return "", fmt.Errorf("%q has no host", rawURL)
+	}
+
+	return parsed.Host, nil
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/files"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/state"
+)
+
+// stringsFlag is a flag that can be passed several times, or once with
+// comma separated values.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, strings.Split(value, ",")...)
+	return nil
+}
+
+// daemon syncs one or more accounts on an interval until it is stopped,
+// keeping the state of each account between runs.
+func daemon(args []string) {
+	var users stringsFlag
+
+	flags, globals := newFlagSet("daemon")
+	flags.Var(&users, "user", "Profile URL, @username@domain handle, or acct:username@domain URI of an account to sync. Pass it once for each account, or separate accounts with commas")
+	dist := flags.String("dist", "./posts", "Path to directory where files will be written")
+	interval := flags.Duration("interval", 15*time.Minute, "Time to wait between the end of a run and the start of the next, such as 15m or 1h")
+	healthAddress := flags.String("health", "", "Address to serve the /health endpoint on, such as localhost:8080. Omit to not serve it")
+	excludeReplies := flags.Bool("exclude-replies", false, "Mastodon API parameter: Filter out statuses in reply to a different account")
+	excludeReblogs := flags.Bool("exclude-reblogs", false, "Mastodon API parameter: Filter out boosts from the response")
+	limit := flags.Int("limit", 40, "Mastodon API parameter: Maximum number of results to return in each request")
+	onlyMedia := flags.Bool("only-media", false, "Mastodon API parameter: Filter out status without attachments")
+	tagged := flags.String("tagged", "", "Mastodon API parameter: Filter for statuses using a specific hashtag")
+	templateFile := flags.String("template", "", "Template to use for post rendering, if passed")
+	filenameTemplate := flags.String("filename", "", "Template for post filename")
+	threaded := flags.Bool("threaded", false, "Thread replies for a post in a single file")
+	visibility := flags.String("visibility", "", "Filter out posts whose visibility does not match the passed visibility value")
+	downloadMedia := flags.String("download-media", "", "Path where post attachments will be downloaded. Omit to skip downloading attachments.")
+	mediaStore := flags.String("media-store", "", "Path to a content-addressed store where downloaded media is kept once and hardlinked into place. Omit to download media directly.")
+	stripMetadata := flags.Bool("strip-metadata", false, "Remove EXIF, XMP and location metadata from downloaded JPEG and PNG files")
+	variantWidths := flags.String("variant-widths", "", "Comma separated widths, in pixels, of resized copies to generate for each downloaded image")
+	variantQuality := flags.Int("variant-quality", 85, "JPEG quality of resized image copies, from 1 to 100")
+	variantCompression := flags.String("variant-png-compression", "default", "PNG compression of resized image copies: default, none, speed, or best")
+	blurhash := flags.Bool("blurhash-placeholders", false, "Write a small placeholder image decoded from each downloaded image's blurhash")
+	mediaFailure := flags.String("media-failure", "warn", "What to do when an attachment cannot be downloaded: skip, warn, or fail")
+	protectEdits := flags.String("protect-edits", "", "Skip files edited after they were generated, or write the post next to them with a .new suffix: skip or new. Omit to overwrite edited files")
+	raw := flags.String("raw", "", "Keep the JSON of each status as returned by the API: sidecar or store. Omit to skip")
+	backend := flags.String("backend", "", "Where posts are read from: mastodon, misskey, or activitypub. Omit to pick from the software the server runs")
+
+	if !globals.parse(flags, args) {
+		return
+	}
+
+	if len(users) == 0 {
+		log.Panicln(errors.New("pass --user at least once"))
+	}
+
+	// Outboxes hold activities rather than statuses, which could not be
+	// rendered again.
+	if *raw != "" && *backend == client.BackendActivityPub {
+		log.Panicln(errors.New("--raw cannot be used with --backend=activitypub"))
+	}
+
+	mediaFailurePolicy, err := files.ParseMediaFailurePolicy(*mediaFailure)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	editPolicy, err := files.ParseEditPolicy(*protectEdits)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	rawPolicy, err := files.ParseRawPolicy(*raw)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	widths, err := files.ParseVariantWidths(*variantWidths)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	pngCompression, err := files.ParsePNGCompression(*variantCompression)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	archiveState, err := state.Load(*dist)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	s := scheduler{
+		users:    users,
+		interval: *interval,
+		state:    archiveState,
+		filters: client.PostsFilter{
+			ExcludeReplies: *excludeReplies,
+			ExcludeReblogs: *excludeReblogs,
+			Limit:          *limit,
+			OnlyMedia:      *onlyMedia,
+			Tagged:         *tagged,
+		},
+		options: client.ClientOptions{
+			Threaded:   *threaded,
+			Visibility: *visibility,
+			Backend:    *backend,
+		},
+		newWriter: func() (files.FileWriter, error) {
+			return files.New(*dist, *templateFile, *filenameTemplate, *downloadMedia, files.FileWriterOptions{
+				MediaFailure:         mediaFailurePolicy,
+				MediaStore:           *mediaStore,
+				StripMetadata:        *stripMetadata,
+				BlurhashPlaceholders: *blurhash,
+				Edits:                editPolicy,
+				Raw:                  rawPolicy,
+				Variants: files.VariantOptions{
+					Widths:         widths,
+					JPEGQuality:    *variantQuality,
+					PNGCompression: pngCompression,
+				},
+			})
+		},
+		clients: make(map[string]*client.Client),
+		health:  newHealth(users),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		// A second signal stops the program right away.
+		stop()
+		log.Println("Stopping once the post being written is done, signal again to stop now")
+	}()
+
+	var server *http.Server
+	// The health endpoint failing stops the runs, and the daemon with it.
+	runCtx, cancelRuns := context.WithCancel(ctx)
+	defer cancelRuns()
+	serverErr := make(chan error, 1)
+
+	if *healthAddress != "" {
+		// Listening first stops the daemon before any run when the address
+		// cannot be used.
+		listener, err := net.Listen("tcp", *healthAddress)
+
+		if err != nil {
+			log.Panicln(err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/health", s.health)
+		server = &http.Server{Handler: mux}
+
+		go func() {
+			if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- err
+				cancelRuns()
+			}
+		}()
+	}
+
+	s.run(runCtx)
+
+	if server != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}
+
+	select {
+	case err := <-serverErr:
+		log.Panicln(fmt.Errorf("error serving the health endpoint: %w", err))
+	default:
+	}
+
+	log.Println("Stopped")
+}
+
+type scheduler struct {
+	users     []string
+	interval  time.Duration
+	state     *state.State
+	filters   client.PostsFilter
+	options   client.ClientOptions
+	newWriter func() (files.FileWriter, error)
+	// Map of user:Client, resolved on the first run that reaches them.
+	clients map[string]*client.Client
+	health  *health
+}
+
+// run syncs every account, then waits for the interval, until ctx is done.
+func (s *scheduler) run(ctx context.Context) {
+	for {
+		for _, user := range s.users {
+			if ctx.Err() != nil {
+				return
+			}
+
+			posts, err := s.sync(ctx, user)
+
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			if err != nil {
+				log.Println(fmt.Sprintf("Could not sync %s: %s", user, err))
+			}
+
+			s.health.record(user, posts, err)
+		}
+
+		log.Println(fmt.Sprintf("Next run in %s", s.interval))
+
+		select {
+		case <-time.After(s.interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sync archives every post of user newer or older than those already
+// archived, and returns how many posts were written.
+func (s *scheduler) sync(ctx context.Context, user string) (int, error) {
+	c, ok := s.clients[user]
+
+	if !ok {
+		resolved, err := client.Resolve(user, s.options)
+
+		if err != nil {
+			return 0, err
+		}
+
+		reportAuthorization(resolved)
+		c = &resolved
+		s.clients[user] = c
+	}
+
+	source := s.state.Source(c.Account())
+
+	if err := c.Sync(ctx, s.filters, source.Newest, source.Oldest); err != nil {
+		// The account is looked up again on the next run, in case it moved
+		// or the server changed.
+		delete(s.clients, user)
+		return 0, err
+	}
+
+	posts := len(c.Posts())
+	fileWriter, err := s.newWriter()
+
+	if err != nil {
+		return 0, err
+	}
+
+	cursors := cursorsAdvance
+
+	// The posts of a filtered sync leave gaps between their bounds, which
+	// a later sync would then skip.
+	if s.filters.Filtered() {
+		cursors = cursorsKeep
+	}
+
+	if err := archivePosts(ctx, s.state, &fileWriter, cursors, c); err != nil {
+		return 0, err
+	}
+
+	stats := fileWriter.Stats()
+	log.Println(fmt.Sprintf("Synced %s, %d posts: created %d files, updated %d, %d unchanged, %d edited locally", user, posts, stats.Created, stats.Updated, stats.Unchanged, stats.Edited))
+
+	return posts, nil
+}
+
+// health tracks the outcome of the latest run of each account, and serves
+// it as JSON.
+type health struct {
+	mu       sync.Mutex
+	Started  time.Time                 `json:"started"`
+	Accounts map[string]*accountHealth `json:"accounts"`
+}
+
+type accountHealth struct {
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// Amount of posts written by the latest successful run.
+	Posts     int    `json:"posts"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func newHealth(users []string) *health {
+	h := &health{
+		Started:  time.Now(),
+		Accounts: make(map[string]*accountHealth),
+	}
+
+	for _, user := range users {
+		h.Accounts[user] = &accountHealth{}
+	}
+
+	return h
+}
+
+func (h *health) record(user string, posts int, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	account := h.Accounts[user]
+	account.LastRun = &now
+
+	if err != nil {
+		account.LastError = err.Error()
+		return
+	}
+
+	account.LastSuccess = &now
+	account.LastError = ""
+	account.Posts = posts
+}
+
+// ServeHTTP responds with 200 while the latest run of every account
+// succeeded, or before the first run is over, and with 503 otherwise.
+func (h *health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	status := http.StatusOK
+
+	for _, account := range h.Accounts {
+		if account.LastError != "" {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(h)
+}
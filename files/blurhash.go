@@ -0,0 +1,226 @@
+package files
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+)
+
+const (
+	base83Characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+	// Width of placeholder images. Their height follows the media's aspect
+	// ratio. Blurhashes carry very little detail, so placeholders are meant
+	// to be scaled up by the browser.
+	placeholderWidth = 32
+)
+
+// decodeBlurhash renders a blurhash as a width by height image.
+// See https://github.com/woltapp/blurhash/blob/master/Algorithm.md
+func decodeBlurhash(hash string, width, height int) (*image.NRGBA, error) {
+	if len(hash) < 6 {
+		return nil, fmt.Errorf("blurhash %q is too short", hash)
+	}
+
+	sizeFlag, err := decodeBase83(hash[0:1])
+
+	if err != nil {
+		return nil, err
+	}
+
+	numX := sizeFlag%9 + 1
+	numY := sizeFlag/9 + 1
+
+	if len(hash) != 4+2*numX*numY {
+		return nil, fmt.Errorf("blurhash %q has an invalid length", hash)
+	}
+
+	quantisedMaximum, err := decodeBase83(hash[1:2])
+
+	if err != nil {
+		return nil, err
+	}
+
+	maximum := float64(quantisedMaximum+1) / 166
+	colors := make([][3]float64, numX*numY)
+
+	for i := range colors {
+		if i == 0 {
+			value, err := decodeBase83(hash[2:6])
+
+			if err != nil {
+				return nil, err
+			}
+
+			colors[i] = [3]float64{
+				srgbToLinear(value >> 16),
+				srgbToLinear((value >> 8) & 255),
+				srgbToLinear(value & 255),
+			}
+
+			continue
+		}
+
+		value, err := decodeBase83(hash[4+i*2 : 6+i*2])
+
+		if err != nil {
+			return nil, err
+		}
+
+		colors[i] = [3]float64{
+			signPow((float64(value/(19*19))-9)/9, 2) * maximum,
+			signPow((float64((value/19)%19)-9)/9, 2) * maximum,
+			signPow((float64(value%19)-9)/9, 2) * maximum,
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var r, g, b float64
+
+			for j := 0; j < numY; j++ {
+				for i := 0; i < numX; i++ {
+					basis := math.Cos(math.Pi*float64(x*i)/float64(width)) *
+						math.Cos(math.Pi*float64(y*j)/float64(height))
+					component := colors[i+j*numX]
+					r += component[0] * basis
+					g += component[1] * basis
+					b += component[2] * basis
+				}
+			}
+
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: linearToSRGB(r),
+				G: linearToSRGB(g),
+				B: linearToSRGB(b),
+				A: 255,
+			})
+		}
+	}
+
+	return img, nil
+}
+
+func decodeBase83(value string) (int, error) {
+	var decoded int
+
+	for _, character := range value {
+		digit := strings.IndexRune(base83Characters, character)
+
+		if digit == -1 {
+			return 0, fmt.Errorf("invalid blurhash character %q", character)
+		}
+
+		decoded = decoded*83 + digit
+	}
+
+	return decoded, nil
+}
+
+func srgbToLinear(value int) float64 {
+	v := float64(value) / 255
+
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) uint8 {
+	v := math.Max(0, math.Min(1, value))
+
+	if v <= 0.0031308 {
+		return uint8(math.Round(v * 12.92 * 255))
+	}
+
+	return uint8(math.Round((1.055*math.Pow(v, 1/2.4) - 0.055) * 255))
+}
+
+func signPow(value, exponent float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exponent), value)
+}
+
+// placeholderSize returns the dimensions of a media's placeholder, keeping
+// the aspect ratio of the original media when it is known.
+func placeholderSize(media client.MediaAttachment) (int, int) {
+	dimensions := media.Meta.Original
+
+	if dimensions.Width == 0 || dimensions.Height == 0 {
+		dimensions = media.Meta.Small
+	}
+
+	if dimensions.Width == 0 || dimensions.Height == 0 {
+		return placeholderWidth, placeholderWidth
+	}
+
+	height := int(math.Round(float64(placeholderWidth*dimensions.Height) / float64(dimensions.Width)))
+
+	return placeholderWidth, max(1, height)
+}
+
+func blurhashPNG(media client.MediaAttachment) ([]byte, error) {
+	width, height := placeholderSize(media)
+	img, err := decodeBlurhash(media.Blurhash, width, height)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+
+	if err := png.Encode(&buffer, img); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// blurhashDataURI is available to templates to inline a media's placeholder
+// as a data URI. Media without a blurhash yields an empty string.
+func blurhashDataURI(media client.MediaAttachment) (string, error) {
+	if media.Blurhash == "" {
+		return "", nil
+	}
+
+	data, err := blurhashPNG(media)
+
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("data:image/png;base64,%s", base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// writePlaceholder saves a media's placeholder in dir as
+// <media id>-blurhash.png.
+func writePlaceholder(media *client.MediaAttachment, dir string) error {
+	data, err := blurhashPNG(*media)
+
+	if err != nil {
+		return err
+	}
+
+	name, err := filepath.Abs(filepath.Join(dir, fmt.Sprintf("%s-blurhash.png", media.Id)))
+
+	if err != nil {
+		return err
+	}
+
+	if err := WriteFileAtomic(name, data); err != nil {
+		return err
+	}
+
+	media.PlaceholderPath = name
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package files
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestDecodeBlurhash(t *testing.T) {
+	// Reference pixels were computed with woltapp's reference decoder.
+	tests := []struct {
+		hash          string
+		width, height int
+		pixels        map[[2]int]color.NRGBA
+	}{
+		{
+			// A single component is a plain color.
+			hash:  "00M{o5",
+			width: 4, height: 4,
+			pixels: map[[2]int]color.NRGBA{
+				{0, 0}: {200, 80, 20, 255},
+				{3, 3}: {200, 80, 20, 255},
+			},
+		},
+		{
+			hash:  "LEHV6nWB2yk8pyo0adR*.7kCMdnj",
+			width: 32, height: 32,
+			pixels: map[[2]int]color.NRGBA{
+				{0, 0}:   {135, 164, 177, 255},
+				{31, 0}:  {137, 166, 181, 255},
+				{16, 16}: {158, 125, 108, 255},
+				{0, 31}:  {136, 144, 147, 255},
+				{31, 31}: {133, 142, 147, 255},
+				{8, 24}:  {148, 139, 133, 255},
+			},
+		},
+		{
+			hash:  "LGF5]+Yk^6#M@-5c,1J5@[or[Q6.",
+			width: 32, height: 20,
+			pixels: map[[2]int]color.NRGBA{
+				{0, 0}:   {176, 118, 163, 255},
+				{31, 19}: {142, 97, 100, 255},
+				{10, 5}:  {123, 128, 194, 255},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.hash, func(t *testing.T) {
+			img, err := decodeBlurhash(test.hash, test.width, test.height)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if size := img.Bounds().Size(); size.X != test.width || size.Y != test.height {
+				t.Fatalf("size = %v, want %dx%d", size, test.width, test.height)
+			}
+
+			for point, want := range test.pixels {
+				if got := img.NRGBAAt(point[0], point[1]); got != want {
+					t.Errorf("pixel %v = %v, want %v", point, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeBlurhashInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"too short", "LEHV6"},
+		{"length does not match the components", "LEHV6nWB2yk8pyo0adR*.7kCMdn"},
+		{"invalid character", "00M{o\""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decodeBlurhash(test.hash, 4, 4); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
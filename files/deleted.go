@@ -0,0 +1,113 @@
+package files
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type DeletedPolicy string
+
+const (
+	// Remove the post's file, or its bundle along with its media.
+	DeletedPolicyDelete DeletedPolicy = "delete"
+	// Move the post's file, or its bundle, to a deleted/ directory.
+	DeletedPolicyMove DeletedPolicy = "move"
+	// Keep the post's file and add a deleted_at field to its front matter.
+	DeletedPolicyMark DeletedPolicy = "mark"
+)
+
+// Directory within the output directory where deleted posts are moved.
+const DeletedDir = "deleted"
+
+var ErrNoFrontMatter = errors.New("file has no front matter")
+
+func ParseDeletedPolicy(policy string) (DeletedPolicy, error) {
+	switch DeletedPolicy(policy) {
+	case DeletedPolicyDelete, DeletedPolicyMove, DeletedPolicyMark:
+		return DeletedPolicy(policy), nil
+	}
+
+	return "", fmt.Errorf("unknown deleted policy %q, expected one of delete, move, mark", policy)
+}
+
+// HandleDeleted applies a policy to the file of a post that was deleted
+// upstream. dir is the directory the file was written to. Returns the
+// file's new path, which is empty when the file was removed.
+func HandleDeleted(dir, path string, policy DeletedPolicy, deletedAt time.Time) (string, error) {
+	target := path
+
+	// Posts bundled with their media live in their own directory, which is
+	// removed or moved as a whole.
+	if bundle := filepath.Dir(path); bundle != filepath.Clean(dir) && strings.HasPrefix(filepath.Base(path), "index.") {
+		target = bundle
+	}
+
+	switch policy {
+	case DeletedPolicyDelete:
+		return "", os.RemoveAll(target)
+	case DeletedPolicyMove:
+		relTarget, err := filepath.Rel(dir, target)
+
+		if err != nil {
+			return "", err
+		}
+
+		movedTarget := filepath.Join(dir, DeletedDir, relTarget)
+
+		if err := os.MkdirAll(filepath.Dir(movedTarget), os.ModePerm); err != nil {
+			return "", err
+		}
+
+		if err := os.Rename(target, movedTarget); err != nil {
+			return "", err
+		}
+
+		return filepath.Join(movedTarget, strings.TrimPrefix(path, target)), nil
+	case DeletedPolicyMark:
+		return path, markDeleted(path, deletedAt)
+	}
+
+	return "", fmt.Errorf("unknown deleted policy %q", policy)
+}
+
+// markDeleted adds a deleted_at field at the end of a file's YAML front
+// matter, unless the file was marked already.
+func markDeleted(path string, deletedAt time.Time) error {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+
+	if len(lines) == 0 || strings.TrimSpace(string(lines[0])) != "---" {
+		return ErrNoFrontMatter
+	}
+
+	for i := 1; i < len(lines); i++ {
+		line := strings.TrimSpace(string(lines[i]))
+
+		if strings.HasPrefix(line, "deleted_at:") {
+			return nil
+		}
+
+		if line != "---" {
+			continue
+		}
+
+		field := fmt.Sprintf("deleted_at: %s\n", deletedAt.Format(time.RFC3339))
+		marked := bytes.Join(lines[:i], nil)
+		marked = append(marked, field...)
+		marked = append(marked, bytes.Join(lines[i:], nil)...)
+
+		return WriteFileAtomic(path, marked)
+	}
+
+	return ErrNoFrontMatter
+}
@@ -0,0 +1,125 @@
+package files
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lines of unchanged context shown around each change.
+const diffContext = 3
+
+type diffLine struct {
+	// One of ' ', '-', or '+'
+	Kind byte
+	Text string
+}
+
+// unifiedDiff returns the changes between two versions of a file in unified
+// diff format. Post files are small, so the longest common subsequence of
+// their lines is computed directly.
+func unifiedDiff(name string, before, after []byte) string {
+	a := splitLines(string(before))
+	b := splitLines(string(after))
+
+	lcs := make([][]int, len(a)+1)
+
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		default:
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", name, name)
+
+	for start := 0; start < len(lines); {
+		if lines[start].Kind == ' ' {
+			start++
+			continue
+		}
+
+		// Extend the hunk until the changes are separated by more than twice
+		// the context.
+		end := start
+
+		for k := start; k < len(lines) && k-end <= 2*diffContext; k++ {
+			if lines[k].Kind != ' ' {
+				end = k + 1
+			}
+		}
+
+		hunkStart := max(0, start-diffContext)
+		hunkEnd := min(len(lines), end+diffContext)
+		writeHunk(&out, lines, hunkStart, hunkEnd)
+		start = hunkEnd
+	}
+
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, lines []diffLine, start, end int) {
+	// Line numbers of the hunk's first line in each version, counted from 1.
+	oldLine, newLine := 1, 1
+
+	for _, line := range lines[:start] {
+		if line.Kind != '+' {
+			oldLine++
+		}
+
+		if line.Kind != '-' {
+			newLine++
+		}
+	}
+
+	var oldCount, newCount int
+
+	for _, line := range lines[start:end] {
+		if line.Kind != '+' {
+			oldCount++
+		}
+
+		if line.Kind != '-' {
+			newCount++
+		}
+	}
+
+	fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)
+
+	for _, line := range lines[start:end] {
+		fmt.Fprintf(out, "%c%s\n", line.Kind, line.Text)
+	}
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
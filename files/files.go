@@ -5,8 +5,7 @@ import (
 	"embed"
 	"fmt"
 	"github.com/Masterminds/sprig/v3"
-	"io"
-	"mime"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -25,6 +24,50 @@ type FileWriter struct {
 	templateFile     string
 	filenameTemplate string
 	downloadMedia    string
+	options          FileWriterOptions
+	store            *mediaStore
+	// List of downloaded files whose location data was stripped.
+	locations []string
+	// List of media files downloaded, or linked from the media store, by
+	// this writer.
+	downloaded []string
+	// List of problems that did not stop the posts from being written.
+	warnings []string
+	stats    WriteStats
+	manifest *manifest
+	// List of files which were not overwritten because they were edited
+	// locally.
+	edited     []string
+	operations []FileOperation
+	httpClient *http.Client
+}
+
+type FileWriterOptions struct {
+	// What to do when a post's attachment cannot be downloaded.
+	MediaFailure MediaFailurePolicy
+	// Directory of a content-addressed store where downloaded media is kept
+	// once and linked into place. Omit to download media straight into place.
+	MediaStore string
+	// Remove EXIF, XMP and other metadata from downloaded JPEG and PNG files.
+	StripMetadata bool
+	// Resized copies to generate for each downloaded image.
+	Variants VariantOptions
+	// Write a small placeholder image decoded from each image's blurhash.
+	BlurhashPlaceholders bool
+	// What to do with files that were edited after they were generated.
+	// Unless overwriting, a manifest of checksums is kept to detect edits.
+	Edits EditPolicy
+	// Whether to keep the JSON of each status as the API returned it.
+	Raw RawPolicy
+	// Where to read attachments with file URLs from, such as the media of
+	// an account export.
+	LocalMedia fs.FS
+	// Never download media, and use the files downloaded by earlier runs
+	// instead.
+	Offline bool
+	// Go through rendering without writing anything to disk, and only
+	// record what would be done.
+	DryRun bool
 }
 
 type TemplateContext struct {
@@ -42,17 +85,21 @@ type FilenameTemplateContext struct {
 	Date FilenameDate
 }
 
-type PostFile struct {
-	Dir  string
-	Name string
-	File *os.File
+// Counts of the files written, by whether the file is new, had different
+// contents, or was left as it was.
+type WriteStats struct {
+	Created   int
+	Updated   int
+	Unchanged int
+	// Files left as they were because they were edited locally.
+	Edited int
 }
 
-func New(dir, templateFile, filenameTemplate, downloadMedia string) (FileWriter, error) {
+func New(dir, templateFile, filenameTemplate, downloadMedia string, opts FileWriterOptions) (FileWriter, error) {
 	var fileWriter FileWriter
 	_, err := os.Stat(dir)
 
-	if os.IsNotExist(err) {
+	if os.IsNotExist(err) && !opts.DryRun {
 		os.Mkdir(dir, os.ModePerm)
 	}
 
@@ -62,27 +109,122 @@ func New(dir, templateFile, filenameTemplate, downloadMedia string) (FileWriter,
 		return fileWriter, err
 	}
 
+	var store *mediaStore
+
+	if opts.MediaStore != "" {
+		store, err = openMediaStore(opts.MediaStore)
+
+		if err != nil {
+			return fileWriter, err
+		}
+	}
+
+	var fileManifest *manifest
+
+	if opts.Edits != EditPolicyOverwrite {
+		fileManifest, err = openManifest(absDir)
+
+		if err != nil {
+			return fileWriter, err
+		}
+	}
+
+	httpClient := &http.Client{}
+
+	if opts.LocalMedia != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.RegisterProtocol("file", http.NewFileTransport(http.FS(opts.LocalMedia)))
+		httpClient.Transport = transport
+	}
+
 	return FileWriter{
 		dir:              absDir,
 		templateFile:     templateFile,
 		filenameTemplate: filenameTemplate,
 		downloadMedia:    downloadMedia,
+		options:          opts,
+		store:            store,
+		manifest:         fileManifest,
+		httpClient:       httpClient,
 	}, nil
 }
 
+// StrippedLocations lists the downloaded files which contained location data
+// before their metadata was stripped.
+func (f FileWriter) StrippedLocations() []string {
+	return f.locations
+}
+
+// DownloadedMedia lists the media files that were downloaded, or linked from
+// the media store, into place.
+func (f FileWriter) DownloadedMedia() []string {
+	return f.downloaded
+}
+
+// Warnings lists the problems that did not stop the posts from being
+// written, such as attachments that could not be downloaded.
+func (f FileWriter) Warnings() []string {
+	return f.warnings
+}
+
+// DryRun reports whether the writer only plans its operations.
+func (f FileWriter) DryRun() bool {
+	return f.options.DryRun
+}
+
+func (f FileWriter) Stats() WriteStats {
+	return f.stats
+}
+
+// EditedFiles lists the files which were not overwritten because they were
+// edited locally.
+func (f FileWriter) EditedFiles() []string {
+	return f.edited
+}
+
+// Close saves the manifest of written files, if one is kept. It can be
+// called more than once, such as in a deferred call that also runs when
+// writing fails partway.
+func (f *FileWriter) Close() error {
+	if f.manifest == nil || f.options.DryRun {
+		return nil
+	}
+
+	return f.manifest.save()
+}
+
+// Write renders a post and writes it to its file. The file is only written
+// when its contents change, so that unchanged files keep their modification
+// time.
 func (f *FileWriter) Write(post *client.Post) error {
-	postFile, err := f.createFile(post)
+	dir, name, err := f.resolvePath(post)
 
 	if err != nil {
 		return err
 	}
-	defer postFile.File.Close()
 
-	if f.downloadMedia != "" && len(post.AllMedia()) > 0 {
+	_, err = os.Stat(dir)
+	if os.IsNotExist(err) && !f.options.DryRun {
+		os.Mkdir(dir, os.ModePerm)
+	}
+
+	if f.downloadMedia != "" && len(post.AllMedia()) > 0 && f.options.DryRun {
+		mediaDir := dir
+
+		if f.downloadMedia != "bundle" {
+			mediaDir = f.downloadMedia
+		}
+
+		f.planAttachments(post.MediaAttachments, mediaDir)
+
+		for _, descendant := range post.Descendants() {
+			f.planAttachments(descendant.MediaAttachments, mediaDir)
+		}
+	} else if f.downloadMedia != "" && len(post.AllMedia()) > 0 {
 		var mediaDir string
 
 		if f.downloadMedia == "bundle" {
-			mediaDir = postFile.Dir
+			mediaDir = dir
 		} else {
 			_, err := os.Stat(f.downloadMedia)
 			if os.IsNotExist(err) {
@@ -92,7 +234,7 @@ func (f *FileWriter) Write(post *client.Post) error {
 		}
 
 		if len(post.MediaAttachments) > 0 {
-			err = downloadAttachments(post.MediaAttachments, mediaDir)
+			err = f.downloadAttachments(post.MediaAttachments, mediaDir)
 			if err != nil {
 				return err
 			}
@@ -100,7 +242,7 @@ func (f *FileWriter) Write(post *client.Post) error {
 
 		for _, descendant := range post.Descendants() {
 			if len(descendant.MediaAttachments) > 0 {
-				err = downloadAttachments(descendant.MediaAttachments, mediaDir)
+				err = f.downloadAttachments(descendant.MediaAttachments, mediaDir)
 				if err != nil {
 					return err
 				}
@@ -109,173 +251,165 @@ func (f *FileWriter) Write(post *client.Post) error {
 	}
 
 	tmpl, err := resolveTemplate(f.templateFile)
-	context := TemplateContext{
-		Post: post,
-	}
-
-	err = tmpl.Execute(postFile.File, context)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (f *FileWriter) formatFilename(post *client.Post) (string, error) {
-	tmplString := "{{.Post.Id}}"
-
-	if f.filenameTemplate != "" {
-		tmplString = f.filenameTemplate
+	context := TemplateContext{
+		Post: post,
 	}
 
-	tmpl := template.Must(template.New("filename").Funcs(sprig.FuncMap()).Parse(tmplString))
+	var content bytes.Buffer
 
-	filenameData := FilenameTemplateContext{
-		Post: post,
+	if err := tmpl.Execute(&content, context); err != nil {
+		return err
 	}
 
-	var nameBuffer bytes.Buffer
+	// The sidecar of a file that already has its extension would be written
+	// over the post.
+	if f.options.Raw == RawPolicySidecar && SidecarPath(name) == name {
+		return fmt.Errorf("the sidecar of %s would be written over the post, as its file name has a .json extension", name)
+	}
 
-	if err := tmpl.Execute(&nameBuffer, filenameData); err != nil {
-		return "", err
+	if err := f.writeFile(name, content.Bytes()); err != nil {
+		return err
 	}
 
-	return nameBuffer.String(), nil
+	return f.writeRaw(post, name)
 }
 
-func (f FileWriter) createFile(post *client.Post) (PostFile, error) {
-	var postFile PostFile
+func (f *FileWriter) writeFile(name string, content []byte) error {
+	existing, err := os.ReadFile(name)
+	created := os.IsNotExist(err)
 
-	outputFilename, err := f.formatFilename(post)
-	extension := filepath.Ext(outputFilename)
-	shouldBundle := f.downloadMedia == "bundle" && len(post.AllMedia()) > 0
-
-	if extension == "" {
-		extension = ".md"
-	} else {
-		outputFilename = strings.TrimSuffix(outputFilename, extension)
+	if err != nil && !created {
+		return err
 	}
 
-	if err != nil {
-		return postFile, err
+	unchanged := !created && bytes.Equal(existing, content)
+	edited := !created && !unchanged && f.manifest != nil && f.manifest.edited(name, existing)
+	operation := FileOperation{
+		Path: name,
+		Kind: "post",
 	}
 
-	if shouldBundle {
-		dir := filepath.Join(f.dir, outputFilename)
+	switch {
+	case created:
+		operation.Action = FileActionCreate
+	case unchanged:
+		operation.Action = FileActionUnchanged
+	case edited:
+		operation.Action = FileActionSkip
+	default:
+		operation.Action = FileActionOverwrite
+	}
 
-		_, err := os.Stat(dir)
-		if os.IsNotExist(err) {
-			os.Mkdir(dir, os.ModePerm)
+	if f.options.DryRun {
+		if operation.Action == FileActionOverwrite {
+			operation.Diff = unifiedDiff(name, existing, content)
 		}
 
-		name := filepath.Join(dir, fmt.Sprintf("index%s", extension))
-		file, err := os.Create(name)
+		f.operations = append(f.operations, operation)
+		return nil
+	}
 
-		if err != nil {
-			return postFile, err
+	f.operations = append(f.operations, operation)
+
+	if unchanged {
+		if f.manifest != nil {
+			f.manifest.record(name, content)
 		}
 
-		postFile = PostFile{
-			Name: name,
-			Dir:  dir,
-			File: file,
+		f.stats.Unchanged++
+		return nil
+	}
+
+	if edited {
+		f.stats.Edited++
+		f.edited = append(f.edited, name)
+
+		if f.options.Edits == EditPolicyNew {
+			return WriteFileAtomic(fmt.Sprintf("%s.new", name), content)
 		}
 
-		return postFile, nil
+		return nil
 	}
 
-	name := filepath.Join(f.dir, fmt.Sprintf("%s%s", outputFilename, extension))
-	file, err := os.Create(name)
+	if err := WriteFileAtomic(name, content); err != nil {
+		return err
+	}
 
-	if err != nil {
-		return postFile, err
+	if f.manifest != nil {
+		f.manifest.record(name, content)
 	}
 
-	postFile = PostFile{
-		Name: name,
-		Dir:  f.dir,
-		File: file,
+	if created {
+		f.stats.Created++
+	} else {
+		f.stats.Updated++
 	}
 
-	return postFile, nil
+	return nil
 }
 
-func downloadAttachments(attachments []client.MediaAttachment, dir string) error {
-	for i := 0; i < len(attachments); i++ {
-		media := &attachments[i]
-		if media.Type != "image" {
-			continue
-		}
+func (f *FileWriter) formatFilename(post *client.Post) (string, error) {
+	tmplString := "{{.Post.Id}}"
 
-		imageFile, err := downloadAttachment(dir, media.Id, media.URL)
+	if f.filenameTemplate != "" {
+		tmplString = f.filenameTemplate
+	}
 
-		if err != nil {
-			return err
-		}
+	tmpl := template.Must(template.New("filename").Funcs(sprig.FuncMap()).Parse(tmplString))
 
-		absImageFile, err := filepath.Abs(imageFile.Name())
+	filenameData := FilenameTemplateContext{
+		Post: post,
+	}
 
-		if err != nil {
-			return err
-		}
+	var nameBuffer bytes.Buffer
 
-		media.Path = absImageFile
+	if err := tmpl.Execute(&nameBuffer, filenameData); err != nil {
+		return "", err
 	}
 
-	return nil
+	return nameBuffer.String(), nil
 }
 
-func downloadAttachment(dir string, id string, url string) (*os.File, error) {
-	var file *os.File
-
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Accept", "image/*")
-	res, err := client.Do(req)
-
-	if err != nil {
-		return file, err
-	}
+// Path returns the path of the file a post is written to.
+func (f FileWriter) Path(post *client.Post) (string, error) {
+	_, name, err := f.resolvePath(post)
 
-	defer res.Body.Close()
+	return name, err
+}
 
-	contentType := res.Header.Get("Content-Type")
-	extensions, err := mime.ExtensionsByType(contentType)
+// resolvePath returns the directory of a post's file, which is also where its
+// media goes when bundling, and the file's path.
+func (f FileWriter) resolvePath(post *client.Post) (string, string, error) {
+	outputFilename, err := f.formatFilename(post)
 
 	if err != nil {
-		return file, err
+		return "", "", err
 	}
 
-	var extension string
-	urlExtension := filepath.Ext(url)
-
-	for _, i := range extensions {
-		if i == urlExtension {
-			extension = i
-			break
-		}
-	}
+	extension := filepath.Ext(outputFilename)
+	shouldBundle := f.downloadMedia == "bundle" && len(post.AllMedia()) > 0
 
 	if extension == "" {
-		return file, fmt.Errorf("could not match extension for media")
+		extension = ".md"
+	} else {
+		outputFilename = strings.TrimSuffix(outputFilename, extension)
 	}
 
-	filename := fmt.Sprintf("%s%s", id, extension)
-	file, err = os.Create(filepath.Join(dir, filename))
+	if shouldBundle {
+		dir := filepath.Join(f.dir, outputFilename)
+		name := filepath.Join(dir, fmt.Sprintf("index%s", extension))
 
-	if err != nil {
-		return file, err
+		return dir, name, nil
 	}
 
-	defer file.Close()
-	_, err = io.Copy(file, res.Body)
-
-	if err != nil {
-		return file, err
-	}
+	name := filepath.Join(f.dir, fmt.Sprintf("%s%s", outputFilename, extension))
 
-	return file, nil
+	return f.dir, name, nil
 }
 
 func resolveTemplate(templateFile string) (*template.Template, error) {
@@ -289,6 +423,7 @@ func resolveTemplate(templateFile string) (*template.Template, error) {
 	funcs := sprig.FuncMap()
 	funcs["toMarkdown"] = converter.ConvertString
 	funcs["toMarkdownEscaped"] = converterEscaped.ConvertString
+	funcs["blurhashDataURI"] = blurhashDataURI
 
 	if templateFile == "" {
 		tmpl, err := template.New("post.tmpl").Funcs(funcs).ParseFS(templates, "templates/*.tmpl")
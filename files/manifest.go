@@ -0,0 +1,186 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Name of the manifest file, kept in the directory where posts are written.
+const ManifestFilename = ".mastodon-markdown-archive-manifest.json"
+
+type EditPolicy string
+
+const (
+	// Overwrite files regardless of local edits. No manifest is kept.
+	EditPolicyOverwrite EditPolicy = ""
+	// Leave files that were edited locally as they are.
+	EditPolicySkip EditPolicy = "skip"
+	// Leave files that were edited locally as they are, and write the newly
+	// rendered post next to them with a .new suffix.
+	EditPolicyNew EditPolicy = "new"
+)
+
+// manifest records the checksum of every file as it was last written, to
+// tell apart files that were edited after they were generated.
+type manifest struct {
+	path string
+	// Map of file path, relative to the manifest's directory:hex encoded
+	// SHA-256 hash of its contents.
+	sums map[string]string
+}
+
+func ParseEditPolicy(policy string) (EditPolicy, error) {
+	switch EditPolicy(policy) {
+	case EditPolicyOverwrite, EditPolicySkip, EditPolicyNew:
+		return EditPolicy(policy), nil
+	}
+
+	return "", fmt.Errorf("unknown edit policy %q, expected one of skip, new", policy)
+}
+
+func openManifest(dir string) (*manifest, error) {
+	m := &manifest{
+		path: filepath.Join(dir, ManifestFilename),
+		sums: make(map[string]string),
+	}
+
+	data, err := os.ReadFile(m.path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &m.sums); err != nil {
+		return nil, fmt.Errorf("error reading manifest: %w", err)
+	}
+
+	return m, nil
+}
+
+func (m *manifest) key(name string) string {
+	key, err := filepath.Rel(filepath.Dir(m.path), name)
+
+	if err != nil {
+		return name
+	}
+
+	return key
+}
+
+// edited reports whether a file's contents differ from what was last
+// written to it. Files that are not in the manifest are not considered
+// edited, since they were generated before the manifest was kept.
+func (m *manifest) edited(name string, contents []byte) bool {
+	sum, ok := m.sums[m.key(name)]
+
+	return ok && sum != checksum(contents)
+}
+
+// ManifestCheck lists the files of a manifest that no longer match it, by
+// their paths relative to the manifest's directory.
+type ManifestCheck struct {
+	// Amount of files in the manifest.
+	Files int
+	// Files whose contents differ from what was last written to them.
+	Edited []string
+	// Files that no longer exist.
+	Missing []string
+}
+
+// CheckManifest compares the files recorded in the manifest in dir with
+// their contents. Without a manifest, no files are checked.
+func CheckManifest(dir string) (ManifestCheck, error) {
+	var check ManifestCheck
+	absDir, err := filepath.Abs(dir)
+
+	if err != nil {
+		return check, err
+	}
+
+	m, err := openManifest(absDir)
+
+	if err != nil {
+		return check, err
+	}
+
+	for key := range m.sums {
+		name := filepath.Join(absDir, key)
+		contents, err := os.ReadFile(name)
+
+		if errors.Is(err, os.ErrNotExist) {
+			check.Missing = append(check.Missing, key)
+			continue
+		}
+
+		if err != nil {
+			return check, err
+		}
+
+		if m.edited(name, contents) {
+			check.Edited = append(check.Edited, key)
+		}
+	}
+
+	check.Files = len(m.sums)
+	slices.Sort(check.Edited)
+	slices.Sort(check.Missing)
+
+	return check, nil
+}
+
+func (m *manifest) record(name string, contents []byte) {
+	m.sums[m.key(name)] = checksum(contents)
+}
+
+func (m *manifest) save() error {
+	data, err := json.MarshalIndent(m.sums, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(m.path, data)
+}
+
+func checksum(contents []byte) string {
+	sum := sha256.Sum256(contents)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// WriteFileAtomic writes to a temporary file in the same directory and
+// renames it into place, so that the file is never left half-written.
+func WriteFileAtomic(name string, contents []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(name), fmt.Sprintf(".%s.tmp-*", filepath.Base(name)))
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), name)
+}
@@ -0,0 +1,545 @@
+package files
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+)
+
+type MediaFailurePolicy string
+
+const (
+	// Leave the attachment without a local path and carry on silently.
+	MediaFailureSkip MediaFailurePolicy = "skip"
+	// Same as skip, but log why the attachment could not be downloaded.
+	MediaFailureWarn MediaFailurePolicy = "warn"
+	// Abort writing the post.
+	MediaFailureFail MediaFailurePolicy = "fail"
+)
+
+// Canonical extension for each media type that may be attached to a post.
+// mime.ExtensionsByType depends on the system's mime tables and may return
+// several extensions, or none, for the same type.
+var mediaExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"image/avif":      ".avif",
+	"image/heic":      ".heic",
+	"image/heif":      ".heif",
+	"image/bmp":       ".bmp",
+	"image/svg+xml":   ".svg",
+	"image/x-icon":    ".ico",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"video/quicktime": ".mov",
+	"audio/mpeg":      ".mp3",
+	"audio/ogg":       ".ogg",
+	"audio/wav":       ".wav",
+	"audio/flac":      ".flac",
+}
+
+// Extensions which are spelled differently but refer to the same media type.
+var extensionAliases = map[string]string{
+	".jpeg": "image/jpeg",
+	".jpe":  "image/jpeg",
+	".jfif": "image/jpeg",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+}
+
+func ParseMediaFailurePolicy(policy string) (MediaFailurePolicy, error) {
+	switch MediaFailurePolicy(policy) {
+	case MediaFailureSkip, MediaFailureWarn, MediaFailureFail:
+		return MediaFailurePolicy(policy), nil
+	case "":
+		return MediaFailureWarn, nil
+	}
+
+	return "", fmt.Errorf("unknown media failure policy %q, expected one of skip, warn, fail", policy)
+}
+
+type downloadedMedia struct {
+	Path      string
+	Extension string
+	// Hex encoded SHA-256 hash of the file's contents.
+	Hash string
+	// Whether the file's metadata was stripped.
+	Stripped bool
+}
+
+func (f *FileWriter) downloadAttachments(attachments []client.MediaAttachment, dir string) error {
+	for i := 0; i < len(attachments); i++ {
+		media := &attachments[i]
+		if media.Type != "image" {
+			continue
+		}
+
+		err := f.fetchAttachment(media, dir)
+
+		if err == nil && len(f.options.Variants.Widths) > 0 {
+			if err = f.generateVariants(media); err != nil {
+				err = fmt.Errorf("error generating variants: %w", err)
+			}
+		}
+
+		if err == nil && f.options.BlurhashPlaceholders && media.Blurhash != "" {
+			if err = writePlaceholder(media, dir); err != nil {
+				err = fmt.Errorf("error writing blurhash placeholder: %w", err)
+			}
+		}
+
+		if err != nil {
+			switch f.options.MediaFailure {
+			case MediaFailureFail:
+				return fmt.Errorf("error downloading media %s: %w", media.Id, err)
+			case MediaFailureWarn:
+				warning := fmt.Sprintf("Skipping media %s: %v", media.Id, err)
+				log.Println(warning)
+				f.warnings = append(f.warnings, warning)
+			}
+		}
+	}
+
+	return nil
+}
+
+type mediaSource struct {
+	Name string
+	URL  string
+}
+
+// mediaSources lists the URLs an attachment may be downloaded from, in order
+// of preference. The remote URL is the original file on the instance where
+// the post was made, which survives the local instance purging its cached
+// copy. The preview is a smaller rendition, but better than nothing.
+func mediaSources(media *client.MediaAttachment) []mediaSource {
+	var sources []mediaSource
+
+	candidates := []mediaSource{
+		{Name: "url", URL: media.URL},
+		{Name: "remote_url", URL: media.RemoteURL},
+		{Name: "preview_url", URL: media.PreviewURL},
+	}
+
+	for _, candidate := range candidates {
+		if candidate.URL == "" || slices.ContainsFunc(sources, func(source mediaSource) bool {
+			return source.URL == candidate.URL
+		}) {
+			continue
+		}
+
+		sources = append(sources, candidate)
+	}
+
+	return sources
+}
+
+// fetchAttachment tries each of the attachment's sources until one of them
+// can be downloaded, and records which one it was.
+func (f *FileWriter) fetchAttachment(media *client.MediaAttachment, dir string) error {
+	if f.options.Offline {
+		return locateAttachment(media, dir)
+	}
+
+	var errs []error
+
+	for _, source := range mediaSources(media) {
+		err := f.fetchAttachmentFrom(media, source.URL, dir)
+
+		if err == nil {
+			media.Source = source.Name
+			return nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", source.Name, err))
+	}
+
+	if len(errs) == 0 {
+		return fmt.Errorf("attachment has no URL")
+	}
+
+	return errors.Join(errs...)
+}
+
+// fetchAttachmentFrom saves the attachment found at url in dir as
+// <media id>.<ext>. When a media store is used, the file is a link to the
+// stored copy and is only downloaded if the store does not have it yet.
+func (f *FileWriter) fetchAttachmentFrom(media *client.MediaAttachment, url, dir string) error {
+	if f.store == nil {
+		download, hadLocation, err := f.download(dir, url)
+
+		if err != nil {
+			return err
+		}
+
+		name := filepath.Join(dir, fmt.Sprintf("%s%s", media.Id, download.Extension))
+
+		if err := os.Rename(download.Path, name); err != nil {
+			os.Remove(download.Path)
+			return err
+		}
+
+		if hadLocation {
+			f.locations = append(f.locations, name)
+		}
+
+		f.downloaded = append(f.downloaded, name)
+
+		return setMediaPath(media, name, download.Hash)
+	}
+
+	blob, stored, ok := f.store.lookup(url)
+
+	// Files stored by runs without --strip-metadata still hold their
+	// metadata, and are stripped into a new file before being linked.
+	if ok && f.options.StripMetadata && !stored.Stripped {
+		var err error
+		blob, stored, err = f.stripStored(url, blob, stored)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !ok {
+		// The store is only created once something is downloaded into it,
+		// so that dry runs leave the disk untouched.
+		if err := os.MkdirAll(f.store.dir, os.ModePerm); err != nil {
+			return err
+		}
+
+		download, hadLocation, err := f.download(f.store.dir, url)
+
+		if err != nil {
+			return err
+		}
+
+		blob, err = f.store.add(url, download)
+
+		if err != nil {
+			os.Remove(download.Path)
+			return err
+		}
+
+		stored = storedMedia{
+			Hash:      download.Hash,
+			Extension: download.Extension,
+			Stripped:  download.Stripped,
+		}
+
+		if hadLocation {
+			f.locations = append(f.locations, blob)
+		}
+	}
+
+	name := filepath.Join(dir, fmt.Sprintf("%s%s", media.Id, stored.Extension))
+
+	if err := linkFile(blob, name); err != nil {
+		return err
+	}
+
+	f.downloaded = append(f.downloaded, name)
+
+	return setMediaPath(media, name, stored.Hash)
+}
+
+// download fetches url into a temporary file within dir and, if enabled,
+// strips the file's metadata. Reports whether the file contained location
+// data.
+func (f *FileWriter) download(dir, url string) (downloadedMedia, bool, error) {
+	download, err := downloadAttachment(f.httpClient, dir, url)
+
+	if err != nil || !f.options.StripMetadata {
+		return download, false, err
+	}
+
+	hadLocation, err := stripMetadata(&download)
+
+	if err != nil {
+		os.Remove(download.Path)
+		return download, false, fmt.Errorf("error stripping metadata: %w", err)
+	}
+
+	download.Stripped = true
+
+	return download, hadLocation, nil
+}
+
+// stripStored strips the metadata of a copy of a stored file, and stores the
+// copy in its place for url. The original file is left for the posts that
+// still link to it, until they are written again.
+func (f *FileWriter) stripStored(url, blob string, stored storedMedia) (string, storedMedia, error) {
+	contents, err := os.ReadFile(blob)
+
+	if err != nil {
+		return "", stored, err
+	}
+
+	file, err := os.CreateTemp(f.store.dir, ".download-*")
+
+	if err != nil {
+		return "", stored, err
+	}
+
+	_, err = file.Write(contents)
+	file.Close()
+
+	if err != nil {
+		os.Remove(file.Name())
+		return "", stored, err
+	}
+
+	download := downloadedMedia{
+		Path:      file.Name(),
+		Extension: stored.Extension,
+		Hash:      stored.Hash,
+	}
+
+	hadLocation, err := stripMetadata(&download)
+
+	if err != nil {
+		os.Remove(download.Path)
+		return "", stored, fmt.Errorf("error stripping metadata: %w", err)
+	}
+
+	download.Stripped = true
+	strippedBlob, err := f.store.add(url, download)
+
+	if err != nil {
+		os.Remove(download.Path)
+		return "", stored, err
+	}
+
+	if hadLocation {
+		f.locations = append(f.locations, strippedBlob)
+	}
+
+	return strippedBlob, storedMedia{
+		Hash:      download.Hash,
+		Extension: download.Extension,
+		Stripped:  true,
+	}, nil
+}
+
+// locateAttachment points the attachment at the file an earlier run saved
+// in dir as <media id>.<ext>, instead of downloading it.
+func locateAttachment(media *client.MediaAttachment, dir string) error {
+	matches, err := filepath.Glob(filepath.Join(dir, fmt.Sprintf("%s.*", media.Id)))
+
+	if err != nil {
+		return err
+	}
+
+	if len(matches) == 0 {
+		return fmt.Errorf("attachment was not downloaded by an earlier run")
+	}
+
+	file, err := os.Open(matches[0])
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+
+	return setMediaPath(media, matches[0], hex.EncodeToString(hash.Sum(nil)))
+}
+
+func setMediaPath(media *client.MediaAttachment, name, hash string) error {
+	absName, err := filepath.Abs(name)
+
+	if err != nil {
+		return err
+	}
+
+	media.Path = absName
+	media.Hash = hash
+
+	return nil
+}
+
+// downloadAttachment fetches url into a temporary file within dir, hashing
+// its contents along the way.
+func downloadAttachment(client *http.Client, dir string, url string) (downloadedMedia, error) {
+	var download downloadedMedia
+
+	req, err := http.NewRequest("GET", url, nil)
+
+	if err != nil {
+		return download, err
+	}
+
+	req.Header.Set("Accept", "image/*")
+	res, err := client.Do(req)
+
+	if err != nil {
+		return download, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return download, fmt.Errorf("unexpected status fetching %s: %s", url, res.Status)
+	}
+
+	// Read enough of the body to sniff its content type, and stitch it back
+	// together with the rest of the body when writing the file.
+	head := make([]byte, 512)
+	n, err := io.ReadFull(res.Body, head)
+
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return download, err
+	}
+
+	head = head[:n]
+	extension, err := resolveExtension(url, res.Header.Get("Content-Type"), head)
+
+	if err != nil {
+		return download, err
+	}
+
+	file, err := os.CreateTemp(dir, ".download-*")
+
+	if err != nil {
+		return download, err
+	}
+
+	defer file.Close()
+
+	hash := sha256.New()
+	body := io.MultiReader(bytes.NewReader(head), res.Body)
+
+	if _, err = io.Copy(io.MultiWriter(file, hash), body); err != nil {
+		os.Remove(file.Name())
+		return download, err
+	}
+
+	download = downloadedMedia{
+		Path:      file.Name(),
+		Extension: extension,
+		Hash:      hex.EncodeToString(hash.Sum(nil)),
+	}
+
+	return download, nil
+}
+
+// resolveExtension picks the extension for a downloaded attachment.
+//
+// The extension in the URL is kept when it agrees with the media type of the
+// response, so that files keep the name the instance gave them. Otherwise the
+// canonical extension for the media type is used, preferring the sniffed type
+// over a missing or generic Content-Type header. As a last resort the URL's
+// extension is used as-is.
+func resolveExtension(rawURL, contentType string, head []byte) (string, error) {
+	urlExtension := urlExtension(rawURL)
+	headerType := parseMediaType(contentType)
+	sniffedType := parseMediaType(http.DetectContentType(head))
+
+	var candidates []string
+
+	for _, mediaType := range []string{headerType, sniffedType} {
+		if !isMediaType(mediaType) {
+			continue
+		}
+
+		candidates = append(candidates, mediaType)
+	}
+
+	if urlExtension != "" {
+		for _, mediaType := range candidates {
+			if extensionType(urlExtension) == mediaType {
+				return urlExtension, nil
+			}
+		}
+	}
+
+	for _, mediaType := range candidates {
+		if extension, ok := mediaExtensions[mediaType]; ok {
+			return extension, nil
+		}
+
+		if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+			return extensions[0], nil
+		}
+	}
+
+	if urlExtension != "" {
+		return urlExtension, nil
+	}
+
+	return "", fmt.Errorf("could not determine extension for media of type %q", contentType)
+}
+
+// urlExtension returns the lowercased extension of the URL's path, ignoring
+// any query string or fragment that a CDN may have appended.
+func urlExtension(rawURL string) string {
+	parsedURL, err := url.Parse(rawURL)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(path.Ext(parsedURL.Path))
+}
+
+func extensionType(extension string) string {
+	if mediaType, ok := extensionAliases[extension]; ok {
+		return mediaType
+	}
+
+	for mediaType, canonical := range mediaExtensions {
+		if canonical == extension {
+			return mediaType
+		}
+	}
+
+	return parseMediaType(mime.TypeByExtension(extension))
+}
+
+// isMediaType reports whether the type describes an image, video or audio
+// file, as opposed to a generic binary type or an error page.
+func isMediaType(mediaType string) bool {
+	for _, prefix := range []string{"image/", "video/", "audio/"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseMediaType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(mediaType)
+}
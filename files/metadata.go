@@ -0,0 +1,300 @@
+package files
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+const (
+	exifOrientationTag = 0x0112
+	exifGPSInfoTag     = 0x8825
+)
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+	xmpHeader    = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// stripMetadata removes EXIF, XMP, IPTC and comment metadata from a JPEG or
+// PNG file in place, without decoding and re-encoding the image. The EXIF
+// orientation of a JPEG is kept so that the image is still displayed the
+// right way up. Other kinds of files are left as they are.
+//
+// Reports whether the file contained location data.
+func stripMetadata(download *downloadedMedia) (bool, error) {
+	data, err := os.ReadFile(download.Path)
+
+	if err != nil {
+		return false, err
+	}
+
+	var stripped []byte
+	var hadLocation bool
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		stripped, hadLocation, err = stripJPEGMetadata(data)
+	case bytes.HasPrefix(data, pngSignature):
+		stripped, hadLocation, err = stripPNGMetadata(data)
+	default:
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(stripped, data) {
+		return hadLocation, nil
+	}
+
+	if err := os.WriteFile(download.Path, stripped, 0644); err != nil {
+		return false, err
+	}
+
+	hash := sha256.Sum256(stripped)
+	download.Hash = hex.EncodeToString(hash[:])
+
+	return hadLocation, nil
+}
+
+func stripJPEGMetadata(data []byte) ([]byte, bool, error) {
+	var out bytes.Buffer
+	var hadLocation bool
+
+	out.Write(data[:2])
+	i := 2
+
+	for i < len(data) {
+		if data[i] != 0xff {
+			return nil, false, fmt.Errorf("malformed jpeg: expected marker at offset %d", i)
+		}
+
+		// Markers may be preceded by any number of fill bytes.
+		for i < len(data) && data[i] == 0xff {
+			i++
+		}
+
+		if i >= len(data) {
+			break
+		}
+
+		marker := data[i]
+		i++
+
+		// Standalone markers carry no length or payload.
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd9) {
+			out.Write([]byte{0xff, marker})
+
+			if marker == 0xd9 {
+				out.Write(data[i:])
+				break
+			}
+
+			continue
+		}
+
+		if i+2 > len(data) {
+			return nil, false, fmt.Errorf("malformed jpeg: truncated segment at offset %d", i)
+		}
+
+		length := int(binary.BigEndian.Uint16(data[i : i+2]))
+
+		if length < 2 || i+length > len(data) {
+			return nil, false, fmt.Errorf("malformed jpeg: bad segment length at offset %d", i)
+		}
+
+		segment := data[i : i+length]
+		payload := segment[2:]
+		i += length
+
+		switch marker {
+		// APP1 holds EXIF or XMP
+		case 0xe1:
+			if bytes.HasPrefix(payload, exifHeader) {
+				tiff := payload[len(exifHeader):]
+				hadLocation = hadLocation || exifHasLocation(tiff)
+
+				if orientation := exifOrientation(tiff); orientation > 1 {
+					writeJPEGSegment(&out, 0xe1, orientationOnlyExif(tiff, orientation))
+				}
+
+				continue
+			}
+
+			if bytes.HasPrefix(payload, xmpHeader) {
+				hadLocation = hadLocation || xmpHasLocation(payload)
+				continue
+			}
+		// APP13 holds Photoshop and IPTC data, COM holds free-form comments
+		case 0xed, 0xfe:
+			continue
+		// Start of scan: the compressed image data follows until EOI
+		case 0xda:
+			out.Write([]byte{0xff, marker})
+			out.Write(segment)
+			out.Write(data[i:])
+			return out.Bytes(), hadLocation, nil
+		}
+
+		out.Write([]byte{0xff, marker})
+		out.Write(segment)
+	}
+
+	return out.Bytes(), hadLocation, nil
+}
+
+func writeJPEGSegment(out *bytes.Buffer, marker byte, payload []byte) {
+	out.Write([]byte{0xff, marker})
+	binary.Write(out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+}
+
+func stripPNGMetadata(data []byte) ([]byte, bool, error) {
+	var out bytes.Buffer
+	var hadLocation bool
+
+	out.Write(pngSignature)
+	i := len(pngSignature)
+
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, false, fmt.Errorf("malformed png: truncated chunk at offset %d", i)
+		}
+
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		chunkType := string(data[i+4 : i+8])
+		end := i + 12 + length
+
+		if end > len(data) {
+			return nil, false, fmt.Errorf("malformed png: bad chunk length at offset %d", i)
+		}
+
+		chunk := data[i:end]
+		payload := chunk[8 : 8+length]
+		i = end
+
+		switch chunkType {
+		case "eXIf":
+			hadLocation = hadLocation || exifHasLocation(payload)
+			continue
+		case "tEXt", "zTXt", "iTXt":
+			hadLocation = hadLocation || xmpHasLocation(payload)
+			continue
+		}
+
+		out.Write(chunk)
+
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	return out.Bytes(), hadLocation, nil
+}
+
+// exifIFD0 returns the byte order of the TIFF structure and the entries of
+// its first image file directory, 12 bytes each.
+func exifIFD0(tiff []byte) (binary.ByteOrder, [][]byte) {
+	if len(tiff) < 8 {
+		return nil, nil
+	}
+
+	var order binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+
+	if offset < 8 || offset+2 > len(tiff) {
+		return order, nil
+	}
+
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	var entries [][]byte
+
+	for n := 0; n < count; n++ {
+		start := offset + 2 + n*12
+
+		if start+12 > len(tiff) {
+			break
+		}
+
+		entries = append(entries, tiff[start:start+12])
+	}
+
+	return order, entries
+}
+
+func exifHasLocation(tiff []byte) bool {
+	order, entries := exifIFD0(tiff)
+
+	for _, entry := range entries {
+		if order.Uint16(entry[0:2]) != exifGPSInfoTag {
+			continue
+		}
+
+		// A GPS directory without any entries holds no location.
+		offset := int(order.Uint32(entry[8:12]))
+
+		if offset+2 > len(tiff) {
+			return true
+		}
+
+		return order.Uint16(tiff[offset:offset+2]) > 0
+	}
+
+	return false
+}
+
+func exifOrientation(tiff []byte) uint16 {
+	order, entries := exifIFD0(tiff)
+
+	for _, entry := range entries {
+		if order.Uint16(entry[0:2]) == exifOrientationTag {
+			return order.Uint16(entry[8:10])
+		}
+	}
+
+	return 0
+}
+
+// orientationOnlyExif builds an EXIF payload whose only tag is the image's
+// orientation, in the same byte order as the original.
+func orientationOnlyExif(tiff []byte, orientation uint16) []byte {
+	order, _ := exifIFD0(tiff)
+
+	var out bytes.Buffer
+	out.Write(exifHeader)
+	out.Write(tiff[:2])
+	binary.Write(&out, order, uint16(42))
+	binary.Write(&out, order, uint32(8))
+	// A single entry of type SHORT with a count of 1, whose value is stored
+	// inline, followed by the offset of the next directory, of which there is
+	// none.
+	binary.Write(&out, order, uint16(1))
+	binary.Write(&out, order, uint16(exifOrientationTag))
+	binary.Write(&out, order, uint16(3))
+	binary.Write(&out, order, uint32(1))
+	binary.Write(&out, order, orientation)
+	binary.Write(&out, order, uint16(0))
+	binary.Write(&out, order, uint32(0))
+
+	return out.Bytes()
+}
+
+func xmpHasLocation(payload []byte) bool {
+	return bytes.Contains(payload, []byte("GPSLatitude")) || bytes.Contains(payload, []byte("GPSLongitude"))
+}
@@ -0,0 +1,387 @@
+package files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testTIFF builds the TIFF structure of an EXIF payload whose first
+// directory holds the image's orientation, unless it is 0, and points to a
+// GPS directory with a latitude when gps is set.
+func testTIFF(order binary.ByteOrder, orientation uint16, gps bool) []byte {
+	var entries [][3]uint32
+
+	if orientation != 0 {
+		// Tag, type SHORT, value stored inline.
+		entries = append(entries, [3]uint32{exifOrientationTag, 3, uint32(orientation)})
+	}
+
+	ifdEnd := uint32(8 + 2 + 12*2 + 4)
+
+	if gps {
+		// Tag, type LONG, offset of the GPS directory.
+		entries = append(entries, [3]uint32{exifGPSInfoTag, 4, ifdEnd})
+	}
+
+	var out bytes.Buffer
+
+	if order == binary.LittleEndian {
+		out.WriteString("II")
+	} else {
+		out.WriteString("MM")
+	}
+
+	binary.Write(&out, order, uint16(42))
+	binary.Write(&out, order, uint32(8))
+	binary.Write(&out, order, uint16(len(entries)))
+
+	for _, entry := range entries {
+		binary.Write(&out, order, uint16(entry[0]))
+		binary.Write(&out, order, uint16(entry[1]))
+		binary.Write(&out, order, uint32(1))
+
+		if entry[1] == 3 {
+			binary.Write(&out, order, uint16(entry[2]))
+			binary.Write(&out, order, uint16(0))
+		} else {
+			binary.Write(&out, order, entry[2])
+		}
+	}
+
+	// Pad the directory to a fixed size, followed by the offset of the next
+	// directory, of which there is none.
+	for n := len(entries); n < 2; n++ {
+		out.Write(make([]byte, 12))
+	}
+
+	binary.Write(&out, order, uint32(0))
+
+	if gps {
+		// GPSLatitude, three RATIONALs stored after the directory.
+		binary.Write(&out, order, uint16(1))
+		binary.Write(&out, order, uint16(2))
+		binary.Write(&out, order, uint16(5))
+		binary.Write(&out, order, uint32(3))
+		binary.Write(&out, order, ifdEnd+2+12+4)
+		binary.Write(&out, order, uint32(0))
+
+		for _, value := range []uint32{52, 1, 22, 1, 0, 1} {
+			binary.Write(&out, order, value)
+		}
+	}
+
+	return out.Bytes()
+}
+
+func testImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 16), G: uint8(y * 32), B: 128, A: 255})
+		}
+	}
+
+	return img
+}
+
+// testJPEG encodes a small image, with the given segments after its start
+// of image marker.
+func testJPEG(t *testing.T, segments ...[]byte) []byte {
+	var encoded bytes.Buffer
+
+	if err := jpeg.Encode(&encoded, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data := encoded.Bytes()
+	var out bytes.Buffer
+	out.Write(data[:2])
+
+	for _, segment := range segments {
+		out.Write(segment)
+	}
+
+	out.Write(data[2:])
+
+	return out.Bytes()
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	var out bytes.Buffer
+	writeJPEGSegment(&out, marker, payload)
+
+	return out.Bytes()
+}
+
+func exifSegment(tiff []byte) []byte {
+	return jpegSegment(0xe1, append(append([]byte{}, exifHeader...), tiff...))
+}
+
+// jpegMetadata returns the payloads of the APP1, APP13 and COM segments of a
+// JPEG file, up to its start of scan.
+func jpegMetadata(data []byte) map[byte][][]byte {
+	segments := make(map[byte][][]byte)
+
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+
+		if marker == 0xda {
+			break
+		}
+
+		if marker == 0xe1 || marker == 0xed || marker == 0xfe {
+			segments[marker] = append(segments[marker], data[i+4:i+2+length])
+		}
+
+		i += 2 + length
+	}
+
+	return segments
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	xmp := append(append([]byte{}, xmpHeader...), []byte(`<x:xmpmeta><rdf:Description exif:GPSLatitude="52,22N"/></x:xmpmeta>`)...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		location bool
+		// Orientation kept in the stripped file, or 0 for no EXIF at all.
+		orientation uint16
+	}{
+		{
+			name:        "GPS and rotated orientation, little endian",
+			data:        testJPEG(t, exifSegment(testTIFF(binary.LittleEndian, 6, true))),
+			location:    true,
+			orientation: 6,
+		},
+		{
+			name:        "GPS and rotated orientation, big endian",
+			data:        testJPEG(t, exifSegment(testTIFF(binary.BigEndian, 8, true))),
+			location:    true,
+			orientation: 8,
+		},
+		{
+			name:     "GPS and default orientation",
+			data:     testJPEG(t, exifSegment(testTIFF(binary.LittleEndian, 1, true))),
+			location: true,
+		},
+		{
+			name: "orientation without GPS",
+			data: testJPEG(t, exifSegment(testTIFF(binary.BigEndian, 3, false))),
+			// The orientation is kept even without a location.
+			orientation: 3,
+		},
+		{
+			name:     "XMP with location, IPTC and comment",
+			data:     testJPEG(t, jpegSegment(0xe1, xmp), jpegSegment(0xed, []byte("Photoshop 3.0\x00")), jpegSegment(0xfe, []byte("taken at home"))),
+			location: true,
+		},
+		{
+			name: "no metadata",
+			data: testJPEG(t),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stripped, location, err := stripJPEGMetadata(test.data)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if location != test.location {
+				t.Errorf("location = %v, want %v", location, test.location)
+			}
+
+			segments := jpegMetadata(stripped)
+
+			if len(segments[0xed]) > 0 || len(segments[0xfe]) > 0 {
+				t.Errorf("IPTC or comment segments were kept")
+			}
+
+			if test.orientation == 0 && len(segments[0xe1]) > 0 {
+				t.Errorf("kept %d APP1 segments, want none", len(segments[0xe1]))
+			}
+
+			if test.orientation != 0 {
+				if len(segments[0xe1]) != 1 || !bytes.HasPrefix(segments[0xe1][0], exifHeader) {
+					t.Fatalf("kept %d APP1 segments, want a single EXIF segment", len(segments[0xe1]))
+				}
+
+				tiff := segments[0xe1][0][len(exifHeader):]
+
+				if orientation := exifOrientation(tiff); orientation != test.orientation {
+					t.Errorf("orientation = %d, want %d", orientation, test.orientation)
+				}
+
+				if exifHasLocation(tiff) {
+					t.Errorf("the kept EXIF still has a location")
+				}
+			}
+
+			if _, err := jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+				t.Errorf("stripped file does not decode: %v", err)
+			}
+
+			if !test.location && test.orientation == 0 && len(jpegMetadata(test.data)) == 0 && !bytes.Equal(stripped, test.data) {
+				t.Errorf("a file without metadata was changed")
+			}
+		})
+	}
+}
+
+func TestStripJPEGMetadataMalformed(t *testing.T) {
+	data := testJPEG(t, exifSegment(testTIFF(binary.LittleEndian, 6, true)))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated segment", data[:6]},
+		{"segment longer than the file", append([]byte{0xff, 0xd8, 0xff, 0xe1, 0xff, 0xff}, make([]byte, 8)...)},
+		{"missing marker", []byte{0xff, 0xd8, 0x00, 0x01}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := stripJPEGMetadata(test.data); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func pngChunk(chunkType string, payload []byte) []byte {
+	var out bytes.Buffer
+	binary.Write(&out, binary.BigEndian, uint32(len(payload)))
+	out.WriteString(chunkType)
+	out.Write(payload)
+	binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(chunkType), payload...)))
+
+	return out.Bytes()
+}
+
+// testPNG encodes a small image, with the given chunks after its header.
+func testPNG(t *testing.T, chunks ...[]byte) []byte {
+	var encoded bytes.Buffer
+
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatal(err)
+	}
+
+	data := encoded.Bytes()
+	// The signature is followed by the IHDR chunk, of 13 bytes.
+	headerEnd := len(pngSignature) + 12 + 13
+	var out bytes.Buffer
+	out.Write(data[:headerEnd])
+
+	for _, chunk := range chunks {
+		out.Write(chunk)
+	}
+
+	out.Write(data[headerEnd:])
+
+	return out.Bytes()
+}
+
+func pngChunkTypes(data []byte) []string {
+	var types []string
+
+	for i := len(pngSignature); i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		types = append(types, string(data[i+4:i+8]))
+		i += 12 + length
+	}
+
+	return types
+}
+
+func TestStripPNGMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		location bool
+	}{
+		{
+			name:     "eXIf with GPS",
+			data:     testPNG(t, pngChunk("eXIf", testTIFF(binary.BigEndian, 6, true))),
+			location: true,
+		},
+		{
+			name: "eXIf without GPS",
+			data: testPNG(t, pngChunk("eXIf", testTIFF(binary.LittleEndian, 1, false))),
+		},
+		{
+			name:     "tEXt with XMP location",
+			data:     testPNG(t, pngChunk("tEXt", []byte("XML:com.adobe.xmp\x00<rdf:Description exif:GPSLongitude=\"4,53E\"/>"))),
+			location: true,
+		},
+		{
+			name: "tEXt, zTXt and iTXt without location",
+			data: testPNG(t, pngChunk("tEXt", []byte("Comment\x00taken at home")), pngChunk("zTXt", []byte("Author\x00\x00x")), pngChunk("iTXt", []byte("Title\x00\x00\x00\x00\x00home"))),
+		},
+		{
+			name: "no metadata",
+			data: testPNG(t),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stripped, location, err := stripPNGMetadata(test.data)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if location != test.location {
+				t.Errorf("location = %v, want %v", location, test.location)
+			}
+
+			for _, chunkType := range pngChunkTypes(stripped) {
+				switch chunkType {
+				case "eXIf", "tEXt", "zTXt", "iTXt":
+					t.Errorf("%s chunk was kept", chunkType)
+				}
+			}
+
+			if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+				t.Errorf("stripped file does not decode: %v", err)
+			}
+
+			if want := testPNG(t); !bytes.Equal(stripped, want) {
+				t.Errorf("stripped file differs from the image without metadata")
+			}
+		})
+	}
+}
+
+func TestStripPNGMetadataMalformed(t *testing.T) {
+	data := testPNG(t, pngChunk("eXIf", testTIFF(binary.BigEndian, 6, true)))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated chunk header", data[:len(pngSignature)+4]},
+		{"chunk longer than the file", data[:len(pngSignature)+20]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := stripPNGMetadata(test.data); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
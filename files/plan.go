@@ -0,0 +1,100 @@
+package files
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+)
+
+type FileAction string
+
+const (
+	FileActionCreate    FileAction = "create"
+	FileActionOverwrite FileAction = "overwrite"
+	FileActionUnchanged FileAction = "unchanged"
+	// The file was edited locally and is left alone.
+	FileActionSkip FileAction = "skip"
+)
+
+// FileOperation describes what was done, or in a dry run what would be done,
+// to a file.
+type FileOperation struct {
+	Path string `json:"path"`
+	// One of "post", "media", or "raw".
+	Kind   string     `json:"kind"`
+	Action FileAction `json:"action"`
+	// Changes to the file's contents, only computed in a dry run.
+	Diff string `json:"diff,omitempty"`
+}
+
+// Operations lists what was done to each file, in the order the files were
+// written.
+func (f FileWriter) Operations() []FileOperation {
+	return f.operations
+}
+
+// PrintPlan writes the operations of a dry run in a human readable form.
+func PrintPlan(w io.Writer, operations []FileOperation) {
+	for _, operation := range operations {
+		fmt.Fprintf(w, "%-9s %-5s %s\n", operation.Action, operation.Kind, operation.Path)
+
+		if operation.Diff != "" {
+			fmt.Fprint(w, operation.Diff)
+		}
+	}
+}
+
+// planAttachments records what downloading the attachments would do,
+// without downloading them. The attachments' paths are set to where they
+// would be downloaded, so that posts render as they would in a real run.
+func (f *FileWriter) planAttachments(attachments []client.MediaAttachment, dir string) {
+	for i := range attachments {
+		media := &attachments[i]
+
+		if media.Type != "image" {
+			continue
+		}
+
+		var extension string
+		action := FileActionCreate
+
+		if f.store != nil {
+			if _, stored, ok := f.store.lookup(media.URL); ok {
+				extension = stored.Extension
+			}
+		}
+
+		// Without the store, guess the extension from a previous download or
+		// from the URL.
+		if extension == "" {
+			if matches, _ := filepath.Glob(filepath.Join(dir, fmt.Sprintf("%s.*", media.Id))); len(matches) > 0 {
+				extension = filepath.Ext(matches[0])
+			} else {
+				extension = urlExtension(media.URL)
+			}
+		}
+
+		name := filepath.Join(dir, fmt.Sprintf("%s%s", media.Id, extension))
+
+		if _, err := os.Stat(name); err == nil {
+			action = FileActionOverwrite
+
+			if f.store != nil {
+				action = FileActionUnchanged
+			}
+		}
+
+		if absName, err := filepath.Abs(name); err == nil {
+			media.Path = absName
+		}
+
+		f.operations = append(f.operations, FileOperation{
+			Path:   name,
+			Kind:   "media",
+			Action: action,
+		})
+	}
+}
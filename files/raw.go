@@ -0,0 +1,189 @@
+package files
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+)
+
+type RawPolicy string
+
+const (
+	// Do not keep the statuses' JSON.
+	RawPolicyNone RawPolicy = ""
+	// Write the JSON of a post and its descendants next to the post's file,
+	// with a .json extension.
+	RawPolicySidecar RawPolicy = "sidecar"
+	// Write the JSON of every status to its own file in a raw/ directory
+	// within the output directory, named after the status' id.
+	RawPolicyStore RawPolicy = "store"
+)
+
+// Directory within the output directory where RawPolicyStore keeps statuses.
+const RawDir = "raw"
+
+func ParseRawPolicy(policy string) (RawPolicy, error) {
+	switch RawPolicy(policy) {
+	case RawPolicyNone, RawPolicySidecar, RawPolicyStore:
+		return RawPolicy(policy), nil
+	}
+
+	return "", fmt.Errorf("unknown raw policy %q, expected sidecar or store", policy)
+}
+
+// SidecarPath returns where RawPolicySidecar writes the JSON of the post
+// written to name.
+func SidecarPath(name string) string {
+	return fmt.Sprintf("%s.json", strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+// writeRaw saves the JSON of a post and its descendants as the API returned
+// it. A sidecar holds an array with the post followed by its descendants.
+func (f *FileWriter) writeRaw(post *client.Post, name string) error {
+	statuses := []*client.Post{post}
+	statuses = append(statuses, post.Descendants()...)
+
+	switch f.options.Raw {
+	case RawPolicySidecar:
+		var raw []json.RawMessage
+
+		for _, status := range statuses {
+			if status.Raw() != nil {
+				raw = append(raw, status.Raw())
+			}
+		}
+
+		data, err := json.Marshal(raw)
+
+		if err != nil {
+			return err
+		}
+
+		return f.writeRawFile(SidecarPath(name), data)
+	case RawPolicyStore:
+		dir := filepath.Join(f.dir, RawDir)
+
+		if !f.options.DryRun {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				return err
+			}
+		}
+
+		for _, status := range statuses {
+			if status.Raw() == nil {
+				continue
+			}
+
+			if err := f.writeRawFile(filepath.Join(dir, fmt.Sprintf("%s.json", status.Id)), status.Raw()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeRawFile indents the JSON in data and writes it to name, unless the
+// file already holds the same contents.
+func (f *FileWriter) writeRawFile(name string, data []byte) error {
+	var content bytes.Buffer
+
+	if err := json.Indent(&content, data, "", "  "); err != nil {
+		return err
+	}
+
+	content.WriteByte('\n')
+
+	operation := FileOperation{
+		Path:   name,
+		Kind:   "raw",
+		Action: FileActionCreate,
+	}
+
+	if existing, err := os.ReadFile(name); err == nil {
+		operation.Action = FileActionOverwrite
+
+		if bytes.Equal(existing, content.Bytes()) {
+			operation.Action = FileActionUnchanged
+		}
+	}
+
+	f.operations = append(f.operations, operation)
+
+	if f.options.DryRun || operation.Action == FileActionUnchanged {
+		return nil
+	}
+
+	return WriteFileAtomic(name, content.Bytes())
+}
+
+// LoadRaw reads the statuses kept by earlier runs in the raw/ directory of
+// dir, and in the sidecars of the given post files. Statuses found in more
+// than one place are returned once.
+func LoadRaw(dir string, names []string) ([]client.Post, error) {
+	var statuses []client.Post
+	seen := make(map[string]bool)
+
+	add := func(status client.Post) {
+		if !seen[status.Id] {
+			seen[status.Id] = true
+			statuses = append(statuses, status)
+		}
+	}
+
+	stored, err := filepath.Glob(filepath.Join(dir, RawDir, "*.json"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range stored {
+		var status client.Post
+
+		if err := readJSON(name, &status); err != nil {
+			return nil, err
+		}
+
+		add(status)
+	}
+
+	for _, name := range names {
+		var sidecar []client.Post
+
+		err := readJSON(SidecarPath(name), &sidecar)
+
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, status := range sidecar {
+			add(status)
+		}
+	}
+
+	return statuses, nil
+}
+
+func readJSON(name string, variable interface{}) error {
+	data, err := os.ReadFile(name)
+
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, variable); err != nil {
+		return fmt.Errorf("error reading %s: %w", name, err)
+	}
+
+	return nil
+}
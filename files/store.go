@@ -0,0 +1,155 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const mediaStoreIndex = "index.json"
+
+// mediaStore keeps a single copy of every downloaded attachment, named after
+// the SHA-256 hash of its contents. Posts reference the stored copies through
+// hardlinks, so an image attached to several posts, or downloaded again on a
+// later run, takes up space only once.
+type mediaStore struct {
+	dir string
+	// Map of MediaAttachment.URL:storedMedia. Lets later runs skip the
+	// download of attachments that are already in the store.
+	index map[string]storedMedia
+}
+
+type storedMedia struct {
+	Hash      string `json:"hash"`
+	Extension string `json:"extension"`
+	// Whether the file's metadata was stripped before it was stored.
+	Stripped bool `json:"stripped,omitempty"`
+}
+
+func openMediaStore(dir string) (*mediaStore, error) {
+	absDir, err := filepath.Abs(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	store := &mediaStore{
+		dir:   absDir,
+		index: make(map[string]storedMedia),
+	}
+
+	data, err := os.ReadFile(filepath.Join(absDir, mediaStoreIndex))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &store.index); err != nil {
+		return nil, fmt.Errorf("error reading media store index: %w", err)
+	}
+
+	return store, nil
+}
+
+func (s *mediaStore) blobPath(hash, extension string) string {
+	return filepath.Join(s.dir, hash[:2], fmt.Sprintf("%s%s", hash, extension))
+}
+
+// lookup returns the stored copy of the media previously downloaded from url.
+func (s *mediaStore) lookup(url string) (string, storedMedia, bool) {
+	stored, ok := s.index[url]
+
+	if !ok {
+		return "", stored, false
+	}
+
+	blob := s.blobPath(stored.Hash, stored.Extension)
+
+	if _, err := os.Stat(blob); err != nil {
+		return "", stored, false
+	}
+
+	return blob, stored, true
+}
+
+// add moves a downloaded file into the store, or discards it when a file with
+// the same contents is already stored, and records where it came from.
+func (s *mediaStore) add(url string, download downloadedMedia) (string, error) {
+	blob := s.blobPath(download.Hash, download.Extension)
+
+	if err := os.MkdirAll(filepath.Dir(blob), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	if _, err := os.Stat(blob); err == nil {
+		os.Remove(download.Path)
+	} else if err := os.Rename(download.Path, blob); err != nil {
+		return "", err
+	}
+
+	s.index[url] = storedMedia{
+		Hash:      download.Hash,
+		Extension: download.Extension,
+		Stripped:  download.Stripped,
+	}
+
+	if err := s.save(); err != nil {
+		return "", err
+	}
+
+	return blob, nil
+}
+
+func (s *mediaStore) save() error {
+	data, err := json.MarshalIndent(s.index, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(filepath.Join(s.dir, mediaStoreIndex), data)
+}
+
+// linkFile makes target point to the same contents as source, preferring a
+// hardlink and falling back to a copy when source and target are on
+// different file systems.
+func linkFile(source, target string) error {
+	if sourceInfo, err := os.Stat(source); err == nil {
+		if targetInfo, err := os.Stat(target); err == nil && os.SameFile(sourceInfo, targetInfo) {
+			return nil
+		}
+	}
+
+	os.Remove(target)
+
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(source)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.Create(target)
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+
+	return err
+}
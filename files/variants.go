@@ -0,0 +1,289 @@
+package files
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+)
+
+type VariantOptions struct {
+	// Widths, in pixels, of the resized copies to generate for each image.
+	// Widths larger than the original image are skipped.
+	Widths []int
+	// JPEG quality of the resized copies, from 1 to 100.
+	JPEGQuality    int
+	PNGCompression png.CompressionLevel
+}
+
+func ParseVariantWidths(widths string) ([]int, error) {
+	var parsed []int
+
+	for _, width := range strings.Split(widths, ",") {
+		width = strings.TrimSpace(width)
+
+		if width == "" {
+			continue
+		}
+
+		value, err := strconv.Atoi(width)
+
+		if err != nil || value <= 0 {
+			return nil, fmt.Errorf("invalid variant width %q", width)
+		}
+
+		parsed = append(parsed, value)
+	}
+
+	slices.Sort(parsed)
+
+	return slices.Compact(parsed), nil
+}
+
+func ParsePNGCompression(level string) (png.CompressionLevel, error) {
+	switch level {
+	case "", "default":
+		return png.DefaultCompression, nil
+	case "none":
+		return png.NoCompression, nil
+	case "speed":
+		return png.BestSpeed, nil
+	case "best":
+		return png.BestCompression, nil
+	}
+
+	return 0, fmt.Errorf("unknown png compression %q, expected one of default, none, speed, best", level)
+}
+
+// generateVariants writes a resized copy of a downloaded image next to it
+// for each configured width, named <media id>-<width>w.<ext>. Copies which
+// already exist are not generated again.
+func (f *FileWriter) generateVariants(media *client.MediaAttachment) error {
+	data, err := os.ReadFile(media.Path)
+
+	if err != nil {
+		return err
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+
+	// Formats that cannot be decoded, and animated GIFs, are kept as they are.
+	if errors.Is(err, image.ErrFormat) || format == "gif" {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("error reading image: %w", err)
+	}
+
+	orientation := jpegOrientation(data)
+
+	// Orientations 5 to 8 rotate the image by 90 degrees.
+	if orientation >= 5 {
+		config.Width, config.Height = config.Height, config.Width
+	}
+
+	extension := filepath.Ext(media.Path)
+	base := strings.TrimSuffix(media.Path, extension)
+	var src image.Image
+	var variants []client.MediaVariant
+
+	for _, width := range f.options.Variants.Widths {
+		if width >= config.Width {
+			break
+		}
+
+		height := max(1, config.Height*width/config.Width)
+		variant := client.MediaVariant{
+			Width:  width,
+			Height: height,
+			Path:   fmt.Sprintf("%s-%dw%s", base, width, extension),
+		}
+
+		if _, err := os.Stat(variant.Path); err == nil {
+			variants = append(variants, variant)
+			continue
+		}
+
+		if src == nil {
+			src, _, err = image.Decode(bytes.NewReader(data))
+
+			if err != nil {
+				return fmt.Errorf("error decoding image: %w", err)
+			}
+
+			src = orient(src, orientation)
+		}
+
+		if err := f.writeVariant(resize(src, width, height), format, variant.Path); err != nil {
+			return err
+		}
+
+		variants = append(variants, variant)
+	}
+
+	media.Variants = append(variants, client.MediaVariant{
+		Width:  config.Width,
+		Height: config.Height,
+		Path:   media.Path,
+	})
+
+	return nil
+}
+
+func (f *FileWriter) writeVariant(img image.Image, format, name string) error {
+	var buffer bytes.Buffer
+	var err error
+
+	switch format {
+	case "jpeg":
+		quality := f.options.Variants.JPEGQuality
+
+		if quality == 0 {
+			quality = jpeg.DefaultQuality
+		}
+
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: quality})
+	case "png":
+		encoder := png.Encoder{CompressionLevel: f.options.Variants.PNGCompression}
+		err = encoder.Encode(&buffer, img)
+	default:
+		err = fmt.Errorf("cannot encode %s images", format)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(name, buffer.Bytes())
+}
+
+// resize scales img down to width by height by averaging the source pixels
+// that each destination pixel covers.
+func resize(img image.Image, width, height int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max(y0+1, (y+1)*srcHeight/height)
+
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max(x0+1, (x+1)*srcWidth/width)
+
+			var r, g, b, a, n int
+
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+
+				for sx := x0; sx < x1; sx++ {
+					pixel := row[sx*4 : sx*4+4]
+					r += int(pixel[0])
+					g += int(pixel[1])
+					b += int(pixel[2])
+					a += int(pixel[3])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG file, or 0 when it
+// has none.
+func jpegOrientation(data []byte) uint16 {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return 0
+	}
+
+	i := 2
+
+	for i+4 <= len(data) && data[i] == 0xff {
+		marker := data[i+1]
+		length := int(data[i+2])<<8 | int(data[i+3])
+
+		// The metadata segments come before the start of scan.
+		if marker == 0xda || i+2+length > len(data) {
+			break
+		}
+
+		payload := data[i+4 : i+2+length]
+
+		if marker == 0xe1 && bytes.HasPrefix(payload, exifHeader) {
+			return exifOrientation(payload[len(exifHeader):])
+		}
+
+		i += 2 + length
+	}
+
+	return 0
+}
+
+// orient applies an EXIF orientation to img, so that the resized copies,
+// which carry no EXIF data, are displayed the right way up.
+func orient(img image.Image, orientation uint16) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	dstWidth, dstHeight := width, height
+
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			}
+
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+
+	return dst
+}
@@ -3,6 +3,7 @@ module git.garrido.io/gabriel/mastodon-markdown-archive
 go 1.21.6
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/JohannesKaufmann/html-to-markdown v1.5.0
 	github.com/Masterminds/sprig/v3 v3.2.3
 )
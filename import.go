@@ -0,0 +1,98 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/files"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/state"
+)
+
+// importArchive writes the posts of an archive requested from Mastodon's
+// settings, without any network access.
+func importArchive(args []string) {
+	flags, globals := newFlagSet("import")
+	archivePath := flags.String("archive", "", "Path to the zip file of the account's archive")
+	dist := flags.String("dist", "./posts", "Path to directory where files will be written")
+	templateFile := flags.String("template", "", "Template to use for post rendering, if passed")
+	filenameTemplate := flags.String("filename", "", "Template for post filename")
+	threaded := flags.Bool("threaded", false, "Thread replies for a post in a single file")
+	visibility := flags.String("visibility", "", "Filter out posts whose visibility does not match the passed visibility value")
+	excludeReplies := flags.Bool("exclude-replies", false, "Filter out posts in reply to a different account")
+	excludeReblogs := flags.Bool("exclude-reblogs", false, "Filter out boosts")
+	downloadMedia := flags.String("download-media", "", "Path where the archive's attachments will be copied. Omit to skip copying attachments.")
+	mediaStore := flags.String("media-store", "", "Path to a content-addressed store where copied media is kept once and hardlinked into place. Omit to copy media directly.")
+	dryRun := flags.Bool("dry-run", false, "Print the files that would be created, overwritten, or left unchanged, without writing anything to disk")
+
+	if !globals.parse(flags, args) {
+		return
+	}
+
+	archive, err := zip.OpenReader(*archivePath)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	defer archive.Close()
+
+	account, posts, err := client.ReadExport(&archive.Reader)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	filters := client.PostsFilter{
+		ExcludeReplies: *excludeReplies,
+		ExcludeReblogs: *excludeReblogs,
+	}
+
+	posts = slices.DeleteFunc(posts, func(post client.Post) bool {
+		return !filters.Matches(post, account.Id)
+	})
+
+	c, err := client.Load(account, posts, client.ClientOptions{
+		Visibility: *visibility,
+		Threaded:   *threaded,
+	})
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	archiveState, err := state.Load(*dist)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	fileWriter, err := files.New(*dist, *templateFile, *filenameTemplate, *downloadMedia, files.FileWriterOptions{
+		MediaFailure: files.MediaFailureWarn,
+		MediaStore:   *mediaStore,
+		LocalMedia:   &archive.Reader,
+		DryRun:       *dryRun,
+	})
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	log.Println(fmt.Sprintf("Read %d posts of %s", len(posts), account.Acct))
+
+	if err := archivePosts(context.Background(), archiveState, &fileWriter, cursorsKeep, &c); err != nil {
+		log.Panicln(err)
+	}
+
+	if *dryRun {
+		files.PrintPlan(os.Stdout, fileWriter.Operations())
+		return
+	}
+
+	stats := fileWriter.Stats()
+	log.Println(fmt.Sprintf("Created %d files, updated %d, %d unchanged, %d edited locally", stats.Created, stats.Updated, stats.Unchanged, stats.Edited))
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/credentials"
+)
+
+// How the authorization code gets back to the login command.
+const (
+	// The instance shows the code, and the user pastes it.
+	RedirectOutOfBand = "oob"
+	// The instance redirects the browser to a server the command listens
+	// with on localhost.
+	RedirectLocal = "local"
+)
+
+// login obtains an access token for an instance with OAuth's authorization
+// code flow, and stores it in the credentials file.
+func login(args []string) {
+	flags, globals := newFlagSet("login")
+	server := flags.String("server", "", "URL or domain of the instance to log in to, or the @username@domain handle of an account on it")
+	redirect := flags.String("redirect", RedirectOutOfBand, "How the authorization code is received: oob, to paste the code the instance shows, or local, to have the browser redirected to a server listening on localhost")
+
+	if !globals.parse(flags, args) {
+		return
+	}
+
+	if *redirect != RedirectOutOfBand && *redirect != RedirectLocal {
+		log.Panicln(fmt.Sprintf("unknown redirect %q, expected oob or local", *redirect))
+	}
+
+	baseURL, err := serverURL(*server)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	store, err := credentials.Load(*globals.credentials)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	redirectURI := client.OutOfBandRedirectURI
+	var listener net.Listener
+
+	if *redirect == RedirectLocal {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+
+		if err != nil {
+			log.Panicln(err)
+		}
+
+		defer listener.Close()
+		redirectURI = fmt.Sprintf("http://%s/callback", listener.Addr())
+	}
+
+	app, err := client.RegisterApp(baseURL, redirectURI, client.LoginScopes)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	state, err := randomState()
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	fmt.Println("Open this page to authorize mastodon-markdown-archive to read your account:")
+	fmt.Println(client.AuthorizeURL(baseURL, app, client.LoginScopes, state))
+
+	var code string
+
+	if listener != nil {
+		code, err = awaitCode(listener, state)
+	} else {
+		code, err = readCode()
+	}
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	token, err := client.RequestToken(baseURL, app, code, client.LoginScopes)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	credential := credentials.Credential{
+		Server:       baseURL,
+		ClientId:     app.ClientId,
+		ClientSecret: app.ClientSecret,
+		AccessToken:  token.AccessToken,
+		Scope:        token.Scope,
+		CreatedAt:    time.Now(),
+	}
+
+	if err := store.Set(credential); err != nil {
+		log.Panicln(err)
+	}
+
+	if err := store.Save(); err != nil {
+		log.Panicln(err)
+	}
+
+	log.Println(fmt.Sprintf("Logged in to %s, token stored in %s", baseURL, store.Path()))
+}
+
+// serverURL returns the base URL of the instance given to --server.
+func serverURL(server string) (string, error) {
+	if server == "" {
+		return "", errors.New("--server is required")
+	}
+
+	if strings.Contains(strings.TrimPrefix(server, "@"), "@") {
+		location, err := client.ResolveUser(server)
+
+		if err != nil {
+			return "", err
+		}
+
+		return location.BaseURL, nil
+	}
+
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+
+	parsed, err := url.Parse(server)
+
+	if err != nil {
+		return "", err
+	}
+
+	if parsed.Host == "" {
+		return "", fmt.Errorf("%q is not the URL or domain of an instance", server)
+	}
+
+	return fmt.Sprintf("%s://%s", parsed.Scheme, parsed.Host), nil
+}
+
+func randomState() (string, error) {
+	data := make([]byte, 16)
+
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(data), nil
+}
+
+// readCode asks the user for the code the instance showed.
+func readCode() (string, error) {
+	fmt.Print("Paste the authorization code: ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	code := strings.TrimSpace(line)
+
+	if code == "" {
+		return "", errors.New("no authorization code given")
+	}
+
+	return code, nil
+}
+
+// awaitCode serves the redirect URI on listener until the instance
+// redirects the browser to it with the authorization code.
+func awaitCode(listener net.Listener, state string) (string, error) {
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		if query.Get("state") != state {
+			http.Error(w, "Unexpected state, try logging in again.", http.StatusBadRequest)
+			return
+		}
+
+		if query.Get("error") != "" {
+			http.Error(w, "Authorization was denied.", http.StatusForbidden)
+			errs <- fmt.Errorf("authorization denied: %s", query.Get("error_description"))
+			return
+		}
+
+		fmt.Fprintln(w, "Logged in, you can close this page.")
+
+		select {
+		case codes <- query.Get("code"):
+		default:
+		}
+	})
+
+	server := &http.Server{Handler: handler}
+	defer server.Close()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errs <- err
+		}
+	}()
+
+	select {
+	case code := <-codes:
+		return code, nil
+	case err := <-errs:
+		return "", err
+	}
+}
+
+// useCredentials sends the tokens stored at path to their instances.
+func useCredentials(path string) {
+	store, err := credentials.Load(path)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	client.UseCredentials(store)
+}
+
+// reportAuthorization logs which account and scopes the token of the
+// account's instance grants, and returns the warnings it logged.
+func reportAuthorization(c client.Client) []string {
+	var warnings []string
+	authorization, ok := c.Authorization()
+
+	if !ok {
+		return warnings
+	}
+
+	scopes := "unknown scopes"
+
+	if len(authorization.Scopes) > 0 {
+		scopes = fmt.Sprintf("scopes %s", strings.Join(authorization.Scopes, " "))
+	}
+
+	log.Println(fmt.Sprintf("Authorized on %s as %s, with %s", authorization.Host, authorization.Account.Acct, scopes))
+
+	for _, scope := range authorization.Scopes {
+		if !strings.HasPrefix(scope, "read") {
+			warning := fmt.Sprintf("The token for %s grants more than read access, log in again for a token that only reads", authorization.Host)
+			log.Println(warning)
+			warnings = append(warnings, warning)
+			break
+		}
+	}
+
+	return warnings
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -9,32 +11,136 @@ import (
 
 	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
 	"git.garrido.io/gabriel/mastodon-markdown-archive/files"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/state"
 )
 
 func main() {
-	dist := flag.String("dist", "./posts", "Path to directory where files will be written")
-	user := flag.String("user", "", "URL of Mastodon account whose toots will be fetched")
-	excludeReplies := flag.Bool("exclude-replies", false, "Mastodon API parameter: Filter out statuses in reply to a different account")
-	excludeReblogs := flag.Bool("exclude-reblogs", false, "Mastodon API parameter: Filter out boosts from the response")
-	limit := flag.Int("limit", 40, "Mastodon API parameter: Maximum number of results to return. Defaults to 20 statuses. Max 40 statuses")
-	onlyMedia := flag.Bool("only-media", false, "Mastodon API parameter: Filter out status without attachments")
-	pinned := flag.Bool("pinned", false, "Mastodon API parameter: Filter for pinned statuses only")
-	sinceId := flag.String("since-id", "", "Mastodon API parameter: All results returned will be greater than this ID. In effect, sets a lower bound on results.")
-	maxId := flag.String("max-id", "", "Mastodon API parameter: All results returned will be lesser than this ID. In effect, sets an upper bound on results.")
-	minId := flag.String("min-id", "", "Mastodon API parameter: Returns results immediately newer than this ID. In effect, sets a cursor at this ID and paginates forward.")
-	tagged := flag.String("tagged", "", "Mastodon API parameter: Filter for statuses using a specific hashtag")
-	persistFirst := flag.String("persist-first", "", "Location to persist the post id of the first post returned")
-	persistLast := flag.String("persist-last", "", "Location to persist the post id of the last post returned")
-	templateFile := flag.String("template", "", "Template to use for post rendering, if passed")
-	threaded := flag.Bool("threaded", false, "Thread replies for a post in a single file")
-	filenameTemplate := flag.String("filename", "", "Template for post filename")
-	porcelain := flag.Bool("porcelain", false, "Prints the amount of fetched posts to stdout in a parsable manner")
-	downloadMedia := flag.String("download-media", "", "Path where post attachments will be downloaded. Omit to skip downloading attachments.")
-	visibility := flag.String("visibility", "", "Filter out posts whose visibility does not match the passed visibility value")
-
-	flag.Parse()
-
-	c, err := client.New(*user, client.PostsFilter{
+	runCommand(os.Args[1:])
+
+	if reportedFailure {
+		os.Exit(1)
+	}
+}
+
+// fetch writes a batch of the account's posts, or every post newer and older
+// than those already archived with --sync. It is what the program does when
+// no command is given.
+func fetch(args []string) {
+	fetchPosts("fetch", args)
+}
+
+// syncPosts writes every post newer and older than those already archived.
+func syncPosts(args []string) {
+	fetchPosts("sync", args)
+}
+
+// fetchPosts runs the fetch and sync commands, which only differ in whether
+// --sync is set by default.
+func fetchPosts(name string, args []string) {
+	flags, globals := newFlagSet(name)
+	dist := flags.String("dist", "./posts", "Path to directory where files will be written")
+	user := flags.String("user", "", "Profile URL, @username@domain handle, or acct:username@domain URI of the account whose toots will be fetched")
+	excludeReplies := flags.Bool("exclude-replies", false, "Mastodon API parameter: Filter out statuses in reply to a different account")
+	excludeReblogs := flags.Bool("exclude-reblogs", false, "Mastodon API parameter: Filter out boosts from the response")
+	limit := flags.Int("limit", 40, "Mastodon API parameter: Maximum number of results to return. Defaults to 20 statuses. Max 40 statuses")
+	onlyMedia := flags.Bool("only-media", false, "Mastodon API parameter: Filter out status without attachments")
+	pinned := flags.Bool("pinned", false, "Mastodon API parameter: Filter for pinned statuses only")
+	sinceId := flags.String("since-id", "", "Mastodon API parameter: All results returned will be greater than this ID. In effect, sets a lower bound on results.")
+	maxId := flags.String("max-id", "", "Mastodon API parameter: All results returned will be lesser than this ID. In effect, sets an upper bound on results.")
+	minId := flags.String("min-id", "", "Mastodon API parameter: Returns results immediately newer than this ID. In effect, sets a cursor at this ID and paginates forward.")
+	tagged := flags.String("tagged", "", "Mastodon API parameter: Filter for statuses using a specific hashtag")
+	persistFirst := flags.String("persist-first", "", "Location to persist the post id of the first post returned")
+	persistLast := flags.String("persist-last", "", "Location to persist the post id of the last post returned")
+	templateFile := flags.String("template", "", "Template to use for post rendering, if passed")
+	threaded := flags.Bool("threaded", false, "Thread replies for a post in a single file")
+	filenameTemplate := flags.String("filename", "", "Template for post filename")
+	porcelain := flags.Bool("porcelain", false, "Prints the amount of fetched posts to stdout in a parsable manner")
+	downloadMedia := flags.String("download-media", "", "Path where post attachments will be downloaded. Omit to skip downloading attachments.")
+	visibility := flags.String("visibility", "", "Filter out posts whose visibility does not match the passed visibility value")
+	mediaStore := flags.String("media-store", "", "Path to a content-addressed store where downloaded media is kept once and hardlinked into place. Omit to download media directly.")
+	stripMetadata := flags.Bool("strip-metadata", false, "Remove EXIF, XMP and location metadata from downloaded JPEG and PNG files")
+	variantWidths := flags.String("variant-widths", "", "Comma separated widths, in pixels, of resized copies to generate for each downloaded image")
+	variantQuality := flags.Int("variant-quality", 85, "JPEG quality of resized image copies, from 1 to 100")
+	variantCompression := flags.String("variant-png-compression", "default", "PNG compression of resized image copies: default, none, speed, or best")
+	blurhash := flags.Bool("blurhash-placeholders", false, "Write a small placeholder image decoded from each downloaded image's blurhash")
+	mediaFailure := flags.String("media-failure", "warn", "What to do when an attachment cannot be downloaded: skip, warn, or fail")
+	protectEdits := flags.String("protect-edits", "", "Keep a manifest of checksums to detect files edited after they were generated, and either skip them or write the post next to them with a .new suffix: skip or new. Omit to overwrite edited files")
+	dryRun := flags.Bool("dry-run", false, "Print the files that would be created, overwritten, or left unchanged, with a diff of overwritten posts, without writing anything to disk")
+	dryRunFormat := flags.String("dry-run-format", "text", "Format of the plan printed by --dry-run: text or json")
+	raw := flags.String("raw", "", "Keep the JSON of each status as returned by the API: sidecar, to write it next to the post's file, or store, to write each status to a raw/ directory in --dist. Omit to skip")
+	backend := flags.String("backend", "", "Where posts are read from: mastodon, to use the Mastodon API, misskey, to use the API of Misskey and its forks such as Sharkey, or activitypub, to read the account's ActivityPub outbox. Omit to pick from the software the server runs")
+	reportFormat := flags.String("report", "", "Write a summary of the run in the given format, json, with the account, the amount of requests, the files written, media downloaded, orphaned posts, cursors, warnings and errors. Omit to skip")
+	reportFile := flags.String("report-file", "", "Path to write the --report to. Omit to print it to stdout")
+	sync := flags.Bool("sync", name == "sync", "Fetch every post newer and older than those already archived, as recorded in the state file in --dist. Ignores --since-id, --max-id, and --min-id")
+
+	if !globals.parse(flags, args) {
+		return
+	}
+
+	if *reportFormat != "" && *reportFormat != "json" {
+		log.Panicln(fmt.Errorf("unknown report format %q, expected json", *reportFormat))
+	}
+
+	// Outboxes hold activities rather than statuses, which could not be
+	// rendered again.
+	if *raw != "" && *backend == client.BackendActivityPub {
+		log.Panicln(errors.New("--raw cannot be used with --backend=activitypub"))
+	}
+
+	// The report would otherwise be printed along with what they print.
+	if *reportFormat != "" && *reportFile == "" && (*porcelain || *dryRun) {
+		log.Panicln(errors.New("pass --report-file to write the --report when printing with --porcelain or --dry-run"))
+	}
+
+	report := newRunReport()
+	report.Profile = *globals.profile
+	report.DryRun = *dryRun
+
+	if *reportFormat != "" {
+		defer report.finish(reportPath(*reportFile, *globals.profile))
+	}
+
+	if *dryRunFormat != "text" && *dryRunFormat != "json" {
+		log.Panicln(fmt.Errorf("unknown dry run format %q, expected text or json", *dryRunFormat))
+	}
+
+	mediaFailurePolicy, err := files.ParseMediaFailurePolicy(*mediaFailure)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	widths, err := files.ParseVariantWidths(*variantWidths)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	pngCompression, err := files.ParsePNGCompression(*variantCompression)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	editPolicy, err := files.ParseEditPolicy(*protectEdits)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	rawPolicy, err := files.ParseRawPolicy(*raw)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	archiveState, err := state.Load(*dist)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	filters := client.PostsFilter{
 		ExcludeReplies: *excludeReplies,
 		ExcludeReblogs: *excludeReblogs,
 		Limit:          *limit,
@@ -44,18 +150,64 @@ func main() {
 		OnlyMedia:      *onlyMedia,
 		Pinned:         *pinned,
 		Tagged:         *tagged,
-	}, client.ClientOptions{
+	}
+
+	opts := client.ClientOptions{
 		Threaded:   *threaded,
 		Visibility: *visibility,
+		Backend:    *backend,
+	}
+
+	c, err := client.Resolve(*user, opts)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	report.setAccount(c.Account())
+	report.Warnings = append(report.Warnings, reportAuthorization(c)...)
+
+	if *sync {
+		source := archiveState.Source(c.Account())
+		err = c.Sync(context.Background(), filters, source.Newest, source.Oldest)
+	} else {
+		err = c.Fetch(filters)
+	}
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	fileWriter, err := files.New(*dist, *templateFile, *filenameTemplate, *downloadMedia, files.FileWriterOptions{
+		MediaFailure:         mediaFailurePolicy,
+		MediaStore:           *mediaStore,
+		StripMetadata:        *stripMetadata,
+		BlurhashPlaceholders: *blurhash,
+		Edits:                editPolicy,
+		Raw:                  rawPolicy,
+		DryRun:               *dryRun,
+		Variants: files.VariantOptions{
+			Widths:         widths,
+			JPEGQuality:    *variantQuality,
+			PNGCompression: pngCompression,
+		},
 	})
 
 	if err != nil {
 		log.Panicln(err)
 	}
 
-	fileWriter, err := files.New(*dist, *templateFile, *filenameTemplate, *downloadMedia)
+	report.fileWriter = &fileWriter
+
 	posts := c.Posts()
 	postsCount := len(posts)
+	report.Posts = postsCount
+	report.Orphans = append(report.Orphans, c.Orphans()...)
+
+	if postsCount > 0 {
+		report.Cursors.First = posts[0].Id
+		report.Cursors.Last = posts[postsCount-1].Id
+	}
 
 	if *porcelain {
 		fmt.Println(postsCount)
@@ -63,14 +215,50 @@ func main() {
 		log.Println(fmt.Sprintf("Fetched %d posts", postsCount))
 	}
 
-	if postsCount == 0 {
+	cursors := cursorsKeep
+
+	// The posts of a fetch with cursors or filters leave gaps between their
+	// bounds, which a later sync would then skip, so only unfiltered syncs
+	// and fetches of the latest posts move the cursors.
+	if !filters.Filtered() && (*sync || (*sinceId == "" && *maxId == "" && *minId == "")) {
+		cursors = cursorsAdvance
+	}
+
+	if err := archivePosts(context.Background(), archiveState, &fileWriter, cursors, &c); err != nil {
+		log.Panicln(err)
+	}
+
+	source := archiveState.Source(c.Account())
+	report.Cursors.Newest = source.Newest
+	report.Cursors.Oldest = source.Oldest
+
+	if *dryRun {
+		if err := printPlan(fileWriter.Operations(), *dryRunFormat); err != nil {
+			log.Panicln(err)
+		}
+
 		return
 	}
 
-	for _, post := range posts {
-		if err := fileWriter.Write(post); err != nil {
-			log.Panicln("error writing post to file: %w", err)
+	if !*porcelain {
+		for _, name := range fileWriter.StrippedLocations() {
+			log.Println(fmt.Sprintf("Removed location data from %s", name))
 		}
+
+		for _, name := range fileWriter.EditedFiles() {
+			if editPolicy == files.EditPolicyNew {
+				log.Println(fmt.Sprintf("Kept %s, which was edited locally, and wrote %s.new", name, name))
+			} else {
+				log.Println(fmt.Sprintf("Skipped %s, which was edited locally", name))
+			}
+		}
+
+		stats := fileWriter.Stats()
+		log.Println(fmt.Sprintf("Created %d files, updated %d, %d unchanged, %d edited locally", stats.Created, stats.Updated, stats.Unchanged, stats.Edited))
+	}
+
+	if postsCount == 0 {
+		return
 	}
 
 	if *persistFirst != "" {
@@ -92,6 +280,17 @@ func main() {
 	}
 }
 
+func printPlan(operations []files.FileOperation, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(operations)
+	}
+
+	files.PrintPlan(os.Stdout, operations)
+	return nil
+}
+
 func persistId(postId string, path string) error {
 	persistPath, err := filepath.Abs(path)
 
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+)
+
+// Options every command takes, as defined by newFlagSet.
+var globalOptionNames = []string{"config", "credentials", "profile"}
+
+// commandOptions maps each command's name to the options it takes, besides
+// the global ones. The configuration file is checked against it without
+// running any command, and each command checks its flags against it when
+// it runs, so that it is kept up to date.
+var commandOptions = map[string][]string{
+	"fetch":     fetchOptionNames,
+	"sync":      fetchOptionNames,
+	"render":    {"blurhash-placeholders", "dist", "download-media", "dry-run", "filename", "protect-edits", "raw", "template", "threaded", "variant-widths", "visibility"},
+	"import":    {"archive", "dist", "download-media", "dry-run", "exclude-reblogs", "exclude-replies", "filename", "media-store", "template", "threaded", "visibility"},
+	"reconcile": {"dist", "policy", "user"},
+	"watch":     {"blurhash-placeholders", "dist", "download-media", "exclude-reblogs", "exclude-replies", "filename", "media-failure", "media-store", "only-media", "policy", "protect-edits", "raw", "strip-metadata", "tagged", "template", "threaded", "user", "variant-png-compression", "variant-quality", "variant-widths", "visibility"},
+	"daemon":    {"backend", "blurhash-placeholders", "dist", "download-media", "exclude-reblogs", "exclude-replies", "filename", "health", "interval", "limit", "media-failure", "media-store", "only-media", "protect-edits", "raw", "strip-metadata", "tagged", "template", "threaded", "user", "variant-png-compression", "variant-quality", "variant-widths", "visibility"},
+	"login":     {"redirect", "server"},
+	"stats":     {"dist", "format"},
+	"verify":    {"allow-edits", "dist"},
+	"serve":     {"address", "dist"},
+}
+
+var fetchOptionNames = []string{"backend", "blurhash-placeholders", "dist", "download-media", "dry-run", "dry-run-format", "exclude-reblogs", "exclude-replies", "filename", "limit", "max-id", "media-failure", "media-store", "min-id", "only-media", "persist-first", "persist-last", "pinned", "porcelain", "protect-edits", "raw", "report", "report-file", "since-id", "strip-metadata", "sync", "tagged", "template", "threaded", "user", "variant-png-compression", "variant-quality", "variant-widths", "visibility"}
+
+// takesOption reports whether the command takes the option.
+func takesOption(command, option string) bool {
+	return slices.Contains(globalOptionNames, option) || slices.Contains(commandOptions[command], option)
+}
+
+// checkFlags reports an error when the flags of a command differ from the
+// options listed for it in commandOptions.
+func checkFlags(flags *flag.FlagSet) error {
+	var names []string
+
+	flags.VisitAll(func(f *flag.Flag) {
+		if !slices.Contains(globalOptionNames, f.Name) {
+			names = append(names, f.Name)
+		}
+	})
+
+	options := slices.Clone(commandOptions[flags.Name()])
+	slices.Sort(options)
+
+	if !slices.Equal(names, options) {
+		return fmt.Errorf("the options of %s are %v, but commandOptions lists %v", flags.Name(), names, options)
+	}
+
+	return nil
+}
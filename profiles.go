@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/config"
+)
+
+// Prefix of the environment variables that set flags, such as
+// MASTODON_ARCHIVE_DIST for --dist.
+const envPrefix = "MASTODON_ARCHIVE_"
+
+// envName returns the environment variable that sets the flag name.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// configure fills the flags that were not passed on the command line, first
+// from their environment variables, then from the profile named by the
+// profile flag in the file of the config flag, on top of the file's
+// defaults. Options of the defaults that are not flags of the command are
+// left to the commands that take them, those of the profile are ignored with
+// a warning, and options that no command takes are rejected.
+func configure(flags *flag.FlagSet) error {
+	passed := make(map[string]bool)
+
+	flags.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+
+	var envErr error
+
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+
+		if !ok || passed[f.Name] || envErr != nil {
+			return
+		}
+
+		if err := flags.Set(f.Name, value); err != nil {
+			envErr = fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), err)
+			return
+		}
+
+		passed[f.Name] = true
+	})
+
+	if envErr != nil {
+		return envErr
+	}
+
+	profile := flags.Lookup("profile").Value.String()
+
+	// Each profile is configured on its own run.
+	if profile == config.AllProfiles {
+		return nil
+	}
+
+	// A file that was asked for must exist, unlike the default one.
+	path := flags.Lookup("config").Value.String()
+	cfg, err := config.Load(path, passed["config"] || profile != "")
+
+	if err != nil {
+		return err
+	}
+
+	if err := checkOptions(cfg, path); err != nil {
+		return err
+	}
+
+	options, err := cfg.Options(profile)
+
+	if err != nil {
+		return err
+	}
+
+	var names []string
+
+	for name := range options {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	for _, name := range names {
+		if name == "config" || name == "profile" {
+			return fmt.Errorf("%s cannot be set in %s", name, path)
+		}
+
+		if !takesOption(flags.Name(), name) {
+			// The defaults are shared by every command, but a profile's
+			// own options are meant for the command it is run with.
+			if _, ok := cfg.Profiles[profile][name]; ok {
+				log.Println(fmt.Sprintf("Ignoring %s of profile %s, which %s does not take", name, profile, flags.Name()))
+			}
+
+			continue
+		}
+
+		if passed[name] {
+			continue
+		}
+
+		value := config.String(options[name])
+
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %s in %s: %w", value, name, path, err)
+		}
+	}
+
+	return nil
+}
+
+// checkOptions rejects the options of the file at path, in its defaults or
+// in any of its profiles, that no command takes, such as misspelled ones.
+func checkOptions(cfg *config.Config, path string) error {
+	known := make(map[string]bool)
+
+	for _, options := range commandOptions {
+		for _, option := range options {
+			known[option] = true
+		}
+	}
+
+	for _, option := range globalOptionNames {
+		known[option] = true
+	}
+
+	sections := map[string]config.Options{"defaults": cfg.Defaults}
+
+	for _, name := range cfg.Names() {
+		sections[fmt.Sprintf("profiles.%s", name)] = cfg.Profiles[name]
+	}
+
+	var names []string
+
+	for name := range sections {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	for _, name := range names {
+		var options []string
+
+		for option := range sections[name] {
+			options = append(options, option)
+		}
+
+		slices.Sort(options)
+
+		for _, option := range options {
+			if !known[option] {
+				return fmt.Errorf("unknown option %s in [%s] of %s", option, name, path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Whether every profile is being run in turn, with --profile=all.
+var allProfiles bool
+
+// runProfiles runs command once for each profile in the file at path, with
+// args selecting the profile.
+func runProfiles(args []string, path string, command func(args []string)) {
+	cfg, err := config.Load(path, true)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	names := cfg.Names()
+
+	if len(names) == 0 {
+		log.Panicln(fmt.Errorf("no profiles in %s", path))
+	}
+
+	allProfiles = true
+	defer func() { allProfiles = false }()
+
+	for _, name := range names {
+		log.Println(fmt.Sprintf("Running profile %s", name))
+
+		// The last --profile flag is the one that counts.
+		profileArgs := append(slices.Clone(args), fmt.Sprintf("--profile=%s", name))
+		command(profileArgs)
+	}
+}
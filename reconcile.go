@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/files"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/state"
+)
+
+// reconcile checks the archived posts of an account against the API, and
+// handles the files of posts which were deleted upstream.
+func reconcile(args []string) {
+	flags, globals := newFlagSet("reconcile")
+	dist := flags.String("dist", "./posts", "Path to directory where files were written")
+	user := flags.String("user", "", "Profile URL, @username@domain handle, or acct:username@domain URI of the account whose archived posts will be checked")
+	policy := flags.String("policy", "mark", "What to do with the file of a deleted post: delete, move, or mark")
+
+	if !globals.parse(flags, args) {
+		return
+	}
+
+	deletedPolicy, err := files.ParseDeletedPolicy(*policy)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	archiveState, err := state.Load(*dist)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	c, err := client.Resolve(*user, client.ClientOptions{})
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	reportAuthorization(c)
+	authorization, authorized := c.Authorization()
+	readsStatuses := authorized && authorization.ReadsStatuses()
+
+	var postIds []string
+	unreadable := 0
+
+	for _, postId := range archiveState.SourcePosts(c.Account()) {
+		postFile := archiveState.Posts[postId]
+
+		if postFile.DeletedAt != nil {
+			continue
+		}
+
+		// The API responds to posts that the client cannot see as it does
+		// to deleted ones, so without a token that reads statuses only the
+		// posts that anyone can see are checked.
+		if !readsStatuses && postFile.Visibility != "public" && postFile.Visibility != "unlisted" {
+			unreadable++
+			continue
+		}
+
+		postIds = append(postIds, postId)
+	}
+
+	if unreadable > 0 {
+		log.Println(fmt.Sprintf("Skipped %d posts that are not public, or whose visibility was not recorded, log in to check them", unreadable))
+	}
+
+	deleted, err := c.FindDeleted(postIds)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	now := time.Now()
+
+	for _, postId := range deleted {
+		if err := handleDeleted(archiveState, postId, deletedPolicy, now); err != nil {
+			log.Panicln(err)
+		}
+	}
+
+	log.Println(fmt.Sprintf("Checked %d posts, found %d deleted", len(postIds), len(deleted)))
+
+	if err := archiveState.Save(); err != nil {
+		log.Panicln(err)
+	}
+}
+
+// handleDeleted applies policy to the file of an archived post which was
+// deleted upstream, and records it in the state.
+func handleDeleted(archiveState *state.State, postId string, policy files.DeletedPolicy, now time.Time) error {
+	// The post may have been forgotten along with the rest of its thread.
+	postFile, ok := archiveState.Posts[postId]
+
+	if !ok {
+		return nil
+	}
+
+	if postFile.Thread != "" {
+		log.Println(fmt.Sprintf("Deleted %s, a reply in the thread of %s, which is still in %s", postId, postFile.Thread, postFile.Path))
+		archiveState.MarkDeleted(postId, now)
+		return nil
+	}
+
+	path, err := files.HandleDeleted(archiveState.Dir(), archiveState.AbsPath(postFile), policy, now)
+
+	if errors.Is(err, files.ErrNoFrontMatter) {
+		log.Println(fmt.Sprintf("Could not mark %s as deleted, %s has no front matter", postId, postFile.Path))
+		return nil
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		log.Println(fmt.Sprintf("Forgot %s, %s no longer exists", postId, postFile.Path))
+		return archiveState.Move(postId, "")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	switch policy {
+	case files.DeletedPolicyDelete:
+		log.Println(fmt.Sprintf("Removed %s, %s", postId, postFile.Path))
+	case files.DeletedPolicyMove:
+		relPath, _ := filepath.Rel(archiveState.Dir(), path)
+		log.Println(fmt.Sprintf("Moved %s, %s to %s", postId, postFile.Path, relPath))
+	case files.DeletedPolicyMark:
+		log.Println(fmt.Sprintf("Marked %s as deleted, %s", postId, postFile.Path))
+		archiveState.MarkDeleted(postId, now)
+		return nil
+	}
+
+	if err := archiveState.Move(postId, path); err != nil {
+		return err
+	}
+
+	if path != "" {
+		archiveState.MarkDeleted(postId, now)
+	}
+
+	return nil
+}
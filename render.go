@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/files"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/state"
+)
+
+// render writes the posts of every archived account again from the statuses
+// kept with --raw, without any network access.
+func render(args []string) {
+	flags, globals := newFlagSet("render")
+	dist := flags.String("dist", "./posts", "Path to directory where files were written")
+	templateFile := flags.String("template", "", "Template to use for post rendering, if passed")
+	filenameTemplate := flags.String("filename", "", "Template for post filename")
+	threaded := flags.Bool("threaded", false, "Thread replies for a post in a single file")
+	visibility := flags.String("visibility", "", "Filter out posts whose visibility does not match the passed visibility value")
+	downloadMedia := flags.String("download-media", "", "Path where post attachments were downloaded, or bundle. Omit to leave media out of posts.")
+	variantWidths := flags.String("variant-widths", "", "Comma separated widths, in pixels, of resized copies to generate for each downloaded image")
+	blurhash := flags.Bool("blurhash-placeholders", false, "Write a small placeholder image decoded from each downloaded image's blurhash")
+	protectEdits := flags.String("protect-edits", "", "Skip files edited after they were generated, or write the post next to them with a .new suffix: skip or new. Omit to overwrite edited files")
+	raw := flags.String("raw", "", "Keep the JSON of each status as it was loaded: sidecar or store. Omit to skip")
+	dryRun := flags.Bool("dry-run", false, "Print the files that would be created, overwritten, or left unchanged, without writing anything to disk")
+
+	if !globals.parse(flags, args) {
+		return
+	}
+
+	widths, err := files.ParseVariantWidths(*variantWidths)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	editPolicy, err := files.ParseEditPolicy(*protectEdits)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	rawPolicy, err := files.ParseRawPolicy(*raw)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	archiveState, err := state.Load(*dist)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	if len(archiveState.Sources) == 0 {
+		log.Panicln(fmt.Errorf("no archived accounts found in %s", *dist))
+	}
+
+	var names []string
+
+	for _, postFile := range archiveState.Posts {
+		if postFile.Thread == "" {
+			names = append(names, archiveState.AbsPath(postFile))
+		}
+	}
+
+	statuses, err := files.LoadRaw(archiveState.Dir(), names)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	// Leave out the account's posts that were deleted upstream, or that
+	// were never archived. Statuses of other accounts are only kept to
+	// rebuild threads.
+	sourceAccounts := make(map[string]bool)
+
+	for _, source := range archiveState.Sources {
+		sourceAccounts[source.AccountId] = true
+	}
+
+	statuses = slices.DeleteFunc(statuses, func(status client.Post) bool {
+		if !sourceAccounts[status.Account.Id] {
+			return false
+		}
+
+		postFile, ok := archiveState.Posts[status.Id]
+
+		return !ok || postFile.DeletedAt != nil
+	})
+
+	fileWriter, err := files.New(*dist, *templateFile, *filenameTemplate, *downloadMedia, files.FileWriterOptions{
+		MediaFailure:         files.MediaFailureWarn,
+		BlurhashPlaceholders: *blurhash,
+		Edits:                editPolicy,
+		Raw:                  rawPolicy,
+		Offline:              true,
+		DryRun:               *dryRun,
+		Variants: files.VariantOptions{
+			Widths: widths,
+		},
+	})
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	var clients []*client.Client
+	postsCount := 0
+
+	for accountURL, source := range archiveState.Sources {
+		index := slices.IndexFunc(statuses, func(status client.Post) bool {
+			return status.Account.Id == source.AccountId
+		})
+
+		if index == -1 {
+			log.Println(fmt.Sprintf("No statuses of %s were kept, run with --raw to keep them", accountURL))
+			continue
+		}
+
+		c, err := client.Load(statuses[index].Account, statuses, client.ClientOptions{
+			Visibility: *visibility,
+			Threaded:   *threaded,
+		})
+
+		if err != nil {
+			log.Panicln(err)
+		}
+
+		clients = append(clients, &c)
+		postsCount += len(c.Posts())
+	}
+
+	if err := archivePosts(context.Background(), archiveState, &fileWriter, cursorsIgnore, clients...); err != nil {
+		log.Panicln(err)
+	}
+
+	if *dryRun {
+		files.PrintPlan(os.Stdout, fileWriter.Operations())
+		return
+	}
+
+	stats := fileWriter.Stats()
+	log.Println(fmt.Sprintf("Rendered %d posts: created %d files, updated %d, %d unchanged, %d edited locally", postsCount, stats.Created, stats.Updated, stats.Unchanged, stats.Edited))
+}
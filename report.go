@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/files"
+)
+
+// Whether a run failed after its report was written. The program then exits
+// with status 1, once every profile was run.
+var reportedFailure bool
+
+// runReport is a summary of what a run did, for scripts to act on.
+type runReport struct {
+	// Name of the profile that was run, if any.
+	Profile string         `json:"profile,omitempty"`
+	Account *reportAccount `json:"account"`
+	// Amount of requests sent to the API, including those to look up the
+	// account and rebuild threads.
+	Requests int `json:"requests"`
+	// Amount of posts fetched, after filtering and threading.
+	Posts int         `json:"posts"`
+	Files reportFiles `json:"files"`
+	Media []string    `json:"media"`
+	// Ids of the posts whose threads were rebuilt from the status context.
+	Orphans  []string      `json:"orphans"`
+	Cursors  reportCursors `json:"cursors"`
+	Warnings []string      `json:"warnings"`
+	Errors   []string      `json:"errors"`
+	DryRun   bool          `json:"dry_run"`
+	// Writer whose files are listed once the run is over, whether or not it
+	// wrote every post.
+	fileWriter *files.FileWriter
+	// Amount of requests sent before the run, such as by the runs of other
+	// profiles.
+	requestsBefore int
+}
+
+type reportAccount struct {
+	Id   string `json:"id"`
+	Acct string `json:"acct"`
+	URL  string `json:"url"`
+}
+
+// reportFiles lists the paths of the files written by a run, by what was
+// done to them.
+type reportFiles struct {
+	Created   []string `json:"created"`
+	Updated   []string `json:"updated"`
+	Unchanged []string `json:"unchanged"`
+	// Files left as they were because they were edited locally.
+	Skipped []string `json:"skipped"`
+}
+
+type reportCursors struct {
+	// Ids of the first and last posts written, as saved by --persist-first
+	// and --persist-last.
+	First string `json:"first,omitempty"`
+	Last  string `json:"last,omitempty"`
+	// Ids of the newest and oldest archived posts of the account, as
+	// recorded in the state file after the run.
+	Newest string `json:"newest,omitempty"`
+	Oldest string `json:"oldest,omitempty"`
+}
+
+func newRunReport() *runReport {
+	// Empty lists are written as such rather than as null.
+	return &runReport{
+		Files: reportFiles{
+			Created:   []string{},
+			Updated:   []string{},
+			Unchanged: []string{},
+			Skipped:   []string{},
+		},
+		Media:          []string{},
+		Orphans:        []string{},
+		Warnings:       []string{},
+		Errors:         []string{},
+		requestsBefore: client.Requests(),
+	}
+}
+
+func (r *runReport) setAccount(account client.Account) {
+	r.Account = &reportAccount{
+		Id:   account.Id,
+		Acct: account.Acct,
+		URL:  account.URL,
+	}
+}
+
+// addFiles sorts the files of the writer's operations by what was done to
+// them. Media is listed on its own, and only when it was downloaded.
+func (r *runReport) addFiles(fileWriter files.FileWriter) {
+	for _, operation := range fileWriter.Operations() {
+		if operation.Kind == "media" {
+			continue
+		}
+
+		switch operation.Action {
+		case files.FileActionCreate:
+			r.Files.Created = append(r.Files.Created, operation.Path)
+		case files.FileActionOverwrite:
+			r.Files.Updated = append(r.Files.Updated, operation.Path)
+		case files.FileActionUnchanged:
+			r.Files.Unchanged = append(r.Files.Unchanged, operation.Path)
+		case files.FileActionSkip:
+			r.Files.Skipped = append(r.Files.Skipped, operation.Path)
+		}
+	}
+
+	r.Media = append(r.Media, fileWriter.DownloadedMedia()...)
+	r.Warnings = append(r.Warnings, fileWriter.Warnings()...)
+}
+
+func (r *runReport) write(w io.Writer) error {
+	r.Requests = client.Requests() - r.requestsBefore
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(r)
+}
+
+// finish writes the report to path, or to stdout without one. It is
+// deferred for the whole run: an error that ends the run with log.Panicln
+// is recovered from and added to the report, so that the remaining profiles
+// still run, and the program exits with status 1 at the end.
+func (r *runReport) finish(path string) {
+	failure := recover()
+
+	if failure != nil {
+		r.Errors = append(r.Errors, strings.TrimSpace(fmt.Sprint(failure)))
+		reportedFailure = true
+	}
+
+	if r.fileWriter != nil {
+		r.addFiles(*r.fileWriter)
+	}
+
+	if err := r.save(path); err != nil {
+		log.Println(fmt.Sprintf("Could not write the report: %s", err))
+		reportedFailure = true
+	}
+}
+
+// reportPath returns where the report of a profile is written. With
+// --profile=all, each profile's report is written next to path, with the
+// profile's name before its extension, so that they don't overwrite one
+// another.
+func reportPath(path, profile string) string {
+	if path == "" || !allProfiles {
+		return path
+	}
+
+	extension := filepath.Ext(path)
+
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(path, extension), profile, extension)
+}
+
+func (r *runReport) save(path string) error {
+	if path == "" {
+		return r.write(os.Stdout)
+	}
+
+	file, err := os.Create(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return r.write(file)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// serve serves the files in --dist over HTTP until it is interrupted, to
+// preview the archive in a browser.
+func serve(args []string) {
+	flags, globals := newFlagSet("serve")
+	dist := flags.String("dist", "./posts", "Path to directory where files were written")
+	address := flags.String("address", "localhost:8000", "Address to serve the archive on")
+
+	if !globals.parse(flags, args) {
+		return
+	}
+
+	server := &http.Server{
+		Addr:    *address,
+		Handler: hideDotfiles(http.FileServer(http.Dir(*dist))),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Println(fmt.Sprintf("Serving %s on http://%s", *dist, *address))
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Panicln(err)
+	}
+
+	log.Println("Stopped")
+}
+
+// hideDotfiles responds with 404 to requests for files or directories whose
+// name starts with a dot, such as the state file and the manifest.
+func hideDotfiles(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, part := range strings.Split(path.Clean(r.URL.Path), "/") {
+			if strings.HasPrefix(part, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,246 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/files"
+)
+
+// Name of the state file, kept in the directory where posts are written.
+const Filename = ".mastodon-markdown-archive.json"
+
+// State records what has been archived so far, so that later runs can pick
+// up where the previous one left off.
+type State struct {
+	LastRun time.Time `json:"last_run"`
+	// Map of source key:Source. A source is an account whose posts are
+	// archived, keyed by the account's URL.
+	Sources map[string]*Source `json:"sources"`
+	// Map of Post.Id:PostFile for every archived post.
+	Posts map[string]PostFile `json:"posts"`
+	dir   string
+}
+
+type Source struct {
+	AccountId string `json:"account_id"`
+	// Ids of the newest and oldest posts archived from this source.
+	Newest  string    `json:"newest"`
+	Oldest  string    `json:"oldest"`
+	LastRun time.Time `json:"last_run"`
+}
+
+type PostFile struct {
+	// Path of the file the post was written to, relative to the state's
+	// directory.
+	Path string `json:"path"`
+	// Id of the thread's top post, when the post was threaded under it.
+	Thread string `json:"thread,omitempty"`
+	// Key of the source the post was archived from.
+	Source string `json:"source"`
+	// Visibility of the post when it was archived. It is empty for posts
+	// archived before it was recorded.
+	Visibility string `json:"visibility,omitempty"`
+	// When the post was found to be deleted upstream, if it was marked as
+	// such instead of being removed.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Load reads the state file in dir. A missing file yields an empty state.
+func Load(dir string) (*State, error) {
+	absDir, err := filepath.Abs(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{
+		Sources: make(map[string]*Source),
+		Posts:   make(map[string]PostFile),
+		dir:     absDir,
+	}
+
+	data, err := os.ReadFile(filepath.Join(absDir, Filename))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+
+	if state.Sources == nil {
+		state.Sources = make(map[string]*Source)
+	}
+
+	if state.Posts == nil {
+		state.Posts = make(map[string]PostFile)
+	}
+
+	return state, nil
+}
+
+func (s *State) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// A run that is stopped while saving leaves the previous state rather
+	// than a truncated one.
+	return files.WriteFileAtomic(filepath.Join(s.dir, Filename), data)
+}
+
+// Source returns the state of the given account, creating it if the account
+// has not been archived before.
+func (s *State) Source(account client.Account) *Source {
+	source, ok := s.Sources[account.URL]
+
+	if !ok {
+		source = &Source{AccountId: account.Id}
+		s.Sources[account.URL] = source
+	}
+
+	return source
+}
+
+// Update widens the range of archived posts to include newest and oldest,
+// the bounds of a batch of consecutive posts. The range is only widened when
+// nothing was archived yet, or when the batch reaches into it, since a
+// later sync would otherwise skip the posts between them.
+func (s *Source) Update(newest, oldest string, lastRun time.Time) {
+	s.LastRun = lastRun
+
+	if newest == "" || oldest == "" {
+		return
+	}
+
+	if s.Newest != "" && (client.CompareIds(oldest, s.Newest) > 0 || client.CompareIds(newest, s.Oldest) < 0) {
+		return
+	}
+
+	if s.Newest == "" || client.CompareIds(newest, s.Newest) > 0 {
+		s.Newest = newest
+	}
+
+	if s.Oldest == "" || client.CompareIds(oldest, s.Oldest) < 0 {
+		s.Oldest = oldest
+	}
+}
+
+// Record tracks the file that a post, and any posts threaded under it, were
+// written to.
+func (s *State) Record(account client.Account, post *client.Post, path string) error {
+	relPath, err := filepath.Rel(s.dir, path)
+
+	if err != nil {
+		return err
+	}
+
+	s.Posts[post.Id] = PostFile{
+		Path:       relPath,
+		Source:     account.URL,
+		Visibility: post.Visibility,
+	}
+
+	for _, descendant := range post.Descendants() {
+		s.Posts[descendant.Id] = PostFile{
+			Path:       relPath,
+			Thread:     post.Id,
+			Source:     account.URL,
+			Visibility: descendant.Visibility,
+		}
+	}
+
+	return nil
+}
+
+// Dir returns the directory of the state file, which paths are relative to.
+func (s State) Dir() string {
+	return s.dir
+}
+
+// AbsPath returns the absolute path of a post's file.
+func (s State) AbsPath(postFile PostFile) string {
+	return filepath.Join(s.dir, postFile.Path)
+}
+
+// SourcePosts returns the ids of the posts archived from the given account.
+func (s State) SourcePosts(account client.Account) []string {
+	var postIds []string
+
+	for postId, postFile := range s.Posts {
+		if postFile.Source == account.URL {
+			postIds = append(postIds, postId)
+		}
+	}
+
+	slices.SortFunc(postIds, client.CompareIds)
+
+	return postIds
+}
+
+// Thread returns the ids of the posts written to the same file as postId,
+// including postId itself.
+func (s State) Thread(postId string) []string {
+	var postIds []string
+	path := s.Posts[postId].Path
+
+	for id, postFile := range s.Posts {
+		if postFile.Path == path {
+			postIds = append(postIds, id)
+		}
+	}
+
+	return postIds
+}
+
+// Move updates the file of postId, and of the posts threaded with it, to
+// path. An empty path forgets the posts altogether.
+func (s *State) Move(postId, path string) error {
+	thread := s.Thread(postId)
+
+	if path == "" {
+		for _, id := range thread {
+			delete(s.Posts, id)
+		}
+
+		return nil
+	}
+
+	relPath, err := filepath.Rel(s.dir, path)
+
+	if err != nil {
+		return err
+	}
+
+	for _, id := range thread {
+		postFile := s.Posts[id]
+		postFile.Path = relPath
+		s.Posts[id] = postFile
+	}
+
+	return nil
+}
+
+func (s *State) MarkDeleted(postId string, deletedAt time.Time) {
+	postFile := s.Posts[postId]
+	postFile.DeletedAt = &deletedAt
+	s.Posts[postId] = postFile
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/state"
+)
+
+type archiveStats struct {
+	Dir     string         `json:"dir"`
+	LastRun *time.Time     `json:"last_run,omitempty"`
+	Sources []*sourceStats `json:"sources"`
+}
+
+// sourceStats counts the posts archived from an account.
+type sourceStats struct {
+	Account string `json:"account"`
+	Posts   int    `json:"posts"`
+	Files   int    `json:"files"`
+	// Amount of posts written to the file of a thread they reply to.
+	Threaded int        `json:"threaded"`
+	Deleted  int        `json:"deleted"`
+	Newest   string     `json:"newest,omitempty"`
+	Oldest   string     `json:"oldest,omitempty"`
+	LastRun  *time.Time `json:"last_run,omitempty"`
+}
+
+// stats shows what the state file in --dist records about the archived
+// accounts, without any network access.
+func stats(args []string) {
+	flags, globals := newFlagSet("stats")
+	dist := flags.String("dist", "./posts", "Path to directory where files were written")
+	format := flags.String("format", "text", "Format of the statistics: text or json")
+
+	if !globals.parse(flags, args) {
+		return
+	}
+
+	if *format != "text" && *format != "json" {
+		log.Panicln(fmt.Errorf("unknown format %q, expected text or json", *format))
+	}
+
+	archiveState, err := state.Load(*dist)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	archive := collectStats(archiveState)
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(archive); err != nil {
+			log.Panicln(err)
+		}
+
+		return
+	}
+
+	printStats(archive)
+}
+
+func collectStats(archiveState *state.State) archiveStats {
+	archive := archiveStats{Dir: archiveState.Dir()}
+
+	if !archiveState.LastRun.IsZero() {
+		archive.LastRun = &archiveState.LastRun
+	}
+
+	sources := make(map[string]*sourceStats)
+	// Map of source key:set of file paths.
+	paths := make(map[string]map[string]bool)
+
+	source := func(key string) *sourceStats {
+		if _, ok := sources[key]; !ok {
+			sources[key] = &sourceStats{Account: key}
+			paths[key] = make(map[string]bool)
+		}
+
+		return sources[key]
+	}
+
+	for key, archived := range archiveState.Sources {
+		s := source(key)
+		s.Newest = archived.Newest
+		s.Oldest = archived.Oldest
+
+		if !archived.LastRun.IsZero() {
+			s.LastRun = &archived.LastRun
+		}
+	}
+
+	for _, postFile := range archiveState.Posts {
+		s := source(postFile.Source)
+		s.Posts++
+		paths[postFile.Source][postFile.Path] = true
+
+		if postFile.Thread != "" {
+			s.Threaded++
+		}
+
+		if postFile.DeletedAt != nil {
+			s.Deleted++
+		}
+	}
+
+	for key, s := range sources {
+		s.Files = len(paths[key])
+		archive.Sources = append(archive.Sources, s)
+	}
+
+	slices.SortFunc(archive.Sources, func(a, b *sourceStats) int {
+		return strings.Compare(a.Account, b.Account)
+	})
+
+	return archive
+}
+
+func printStats(archive archiveStats) {
+	fmt.Println(archive.Dir)
+
+	if archive.LastRun == nil {
+		fmt.Println("Nothing has been archived yet")
+		return
+	}
+
+	fmt.Printf("Last run: %s\n", archive.LastRun.Format(time.RFC3339))
+
+	for _, s := range archive.Sources {
+		fmt.Printf("\n%s\n", s.Account)
+		fmt.Printf("  Posts:    %d, in %d files\n", s.Posts, s.Files)
+		fmt.Printf("  Threaded: %d\n", s.Threaded)
+		fmt.Printf("  Deleted:  %d\n", s.Deleted)
+
+		if s.Newest != "" {
+			fmt.Printf("  Newest:   %s\n", s.Newest)
+			fmt.Printf("  Oldest:   %s\n", s.Oldest)
+		}
+
+		if s.LastRun != nil {
+			fmt.Printf("  Last run: %s\n", s.LastRun.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/files"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/state"
+)
+
+// verify checks that the file of every archived post is in place, and that
+// the files recorded in the manifest kept with --protect-edits were not
+// edited. It exits with status 1 when any check fails.
+func verify(args []string) {
+	flags, globals := newFlagSet("verify")
+	dist := flags.String("dist", "./posts", "Path to directory where files were written")
+	allowEdits := flags.Bool("allow-edits", false, "Report files edited after they were generated without failing")
+
+	if !globals.parse(flags, args) {
+		return
+	}
+
+	archiveState, err := state.Load(*dist)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	missing, err := missingFiles(archiveState)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	for _, path := range missing {
+		log.Println(fmt.Sprintf("Missing %s", path))
+	}
+
+	check, err := files.CheckManifest(*dist)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	for _, path := range check.Missing {
+		if !slices.Contains(missing, path) {
+			log.Println(fmt.Sprintf("Missing %s", path))
+			missing = append(missing, path)
+		}
+	}
+
+	for _, path := range check.Edited {
+		log.Println(fmt.Sprintf("Edited %s", path))
+	}
+
+	log.Println(fmt.Sprintf("Checked %d posts and %d files in the manifest: %d missing, %d edited locally", len(archiveState.Posts), check.Files, len(missing), len(check.Edited)))
+
+	if len(missing) > 0 || (len(check.Edited) > 0 && !*allowEdits) {
+		os.Exit(1)
+	}
+}
+
+// missingFiles returns the files of archived posts that do not exist,
+// relative to the state's directory.
+func missingFiles(archiveState *state.State) ([]string, error) {
+	var missing []string
+	checked := make(map[string]bool)
+
+	for _, postFile := range archiveState.Posts {
+		if checked[postFile.Path] {
+			continue
+		}
+
+		checked[postFile.Path] = true
+		_, err := os.Stat(archiveState.AbsPath(postFile))
+
+		if errors.Is(err, os.ErrNotExist) {
+			missing = append(missing, postFile.Path)
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slices.Sort(missing)
+
+	return missing, nil
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"git.garrido.io/gabriel/mastodon-markdown-archive/client"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/files"
+	"git.garrido.io/gabriel/mastodon-markdown-archive/state"
+)
+
+// Bounds of the delay before reconnecting to the streaming API, which
+// doubles after each failed attempt.
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 5 * time.Minute
+)
+
+// watch archives the account's posts as they are published, edited, and
+// deleted, from the streaming API, until it is interrupted.
+func watch(args []string) {
+	flags, globals := newFlagSet("watch")
+	dist := flags.String("dist", "./posts", "Path to directory where files will be written")
+	user := flags.String("user", "", "Profile URL, @username@domain handle, or acct:username@domain URI of the account whose posts will be watched. A token for its instance is required")
+	excludeReplies := flags.Bool("exclude-replies", false, "Filter out statuses in reply to a different account")
+	excludeReblogs := flags.Bool("exclude-reblogs", false, "Filter out boosts")
+	onlyMedia := flags.Bool("only-media", false, "Filter out status without attachments")
+	tagged := flags.String("tagged", "", "Filter for statuses using a specific hashtag")
+	templateFile := flags.String("template", "", "Template to use for post rendering, if passed")
+	filenameTemplate := flags.String("filename", "", "Template for post filename")
+	threaded := flags.Bool("threaded", false, "Thread replies for a post in a single file")
+	visibility := flags.String("visibility", "", "Filter out posts whose visibility does not match the passed visibility value")
+	downloadMedia := flags.String("download-media", "", "Path where post attachments will be downloaded. Omit to skip downloading attachments.")
+	mediaStore := flags.String("media-store", "", "Path to a content-addressed store where downloaded media is kept once and hardlinked into place. Omit to download media directly.")
+	stripMetadata := flags.Bool("strip-metadata", false, "Remove EXIF, XMP and location metadata from downloaded JPEG and PNG files")
+	variantWidths := flags.String("variant-widths", "", "Comma separated widths, in pixels, of resized copies to generate for each downloaded image")
+	variantQuality := flags.Int("variant-quality", 85, "JPEG quality of resized image copies, from 1 to 100")
+	variantCompression := flags.String("variant-png-compression", "default", "PNG compression of resized image copies: default, none, speed, or best")
+	blurhash := flags.Bool("blurhash-placeholders", false, "Write a small placeholder image decoded from each downloaded image's blurhash")
+	mediaFailure := flags.String("media-failure", "warn", "What to do when an attachment cannot be downloaded: skip, warn, or fail")
+	protectEdits := flags.String("protect-edits", "", "Skip files edited after they were generated, or write the post next to them with a .new suffix: skip or new. Omit to overwrite edited files")
+	raw := flags.String("raw", "", "Keep the JSON of each status as returned by the API: sidecar or store. Omit to skip")
+	policy := flags.String("policy", "mark", "What to do with the file of a post that is deleted: delete, move, or mark")
+
+	if !globals.parse(flags, args) {
+		return
+	}
+
+	deletedPolicy, err := files.ParseDeletedPolicy(*policy)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	mediaFailurePolicy, err := files.ParseMediaFailurePolicy(*mediaFailure)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	editPolicy, err := files.ParseEditPolicy(*protectEdits)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	rawPolicy, err := files.ParseRawPolicy(*raw)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	widths, err := files.ParseVariantWidths(*variantWidths)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	pngCompression, err := files.ParsePNGCompression(*variantCompression)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	archiveState, err := state.Load(*dist)
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	c, err := client.Resolve(*user, client.ClientOptions{
+		Threaded:   *threaded,
+		Visibility: *visibility,
+		Backend:    client.BackendMastodon,
+	})
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	reportAuthorization(c)
+	stream, err := c.UserStream()
+
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	w := watcher{
+		client: &c,
+		stream: stream,
+		state:  archiveState,
+		filters: client.PostsFilter{
+			ExcludeReplies: *excludeReplies,
+			ExcludeReblogs: *excludeReblogs,
+			Limit:          c.Software().MaxLimit(),
+			OnlyMedia:      *onlyMedia,
+			Tagged:         *tagged,
+		},
+		deletedPolicy: deletedPolicy,
+		newWriter: func() (files.FileWriter, error) {
+			return files.New(*dist, *templateFile, *filenameTemplate, *downloadMedia, files.FileWriterOptions{
+				MediaFailure:         mediaFailurePolicy,
+				MediaStore:           *mediaStore,
+				StripMetadata:        *stripMetadata,
+				BlurhashPlaceholders: *blurhash,
+				Edits:                editPolicy,
+				Raw:                  rawPolicy,
+				Variants: files.VariantOptions{
+					Widths:         widths,
+					JPEGQuality:    *variantQuality,
+					PNGCompression: pngCompression,
+				},
+			})
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := w.run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Panicln(err)
+	}
+
+	log.Println("Stopped watching")
+}
+
+type watcher struct {
+	client        *client.Client
+	stream        client.UserStream
+	state         *state.State
+	filters       client.PostsFilter
+	deletedPolicy files.DeletedPolicy
+	newWriter     func() (files.FileWriter, error)
+}
+
+// run connects to the stream, and connects again with a growing delay each
+// time the connection drops. Once connected, it catches up with the posts
+// that were published since the newest archived post.
+func (w *watcher) run(ctx context.Context) error {
+	delay := minReconnectDelay
+
+	for {
+		events := make(chan client.StreamEvent)
+		streamErr := make(chan error, 1)
+		connected := time.Now()
+
+		go func() {
+			streamErr <- w.stream.Stream(ctx, events)
+		}()
+
+		// Posts published while connecting are both fetched and streamed,
+		// which only writes them twice.
+		if err := w.catchUp(ctx); err != nil {
+			log.Println(fmt.Sprintf("Could not catch up with new posts: %s", err))
+		}
+
+		err := w.receive(ctx, events, streamErr)
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// A connection that held for a while was not refused, so the delay
+		// starts over.
+		if time.Since(connected) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+
+		log.Println(fmt.Sprintf("Lost the stream: %s, reconnecting in %s", err, delay))
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// receive handles events until the stream ends, and returns why it ended.
+func (w *watcher) receive(ctx context.Context, events <-chan client.StreamEvent, streamErr <-chan error) error {
+	for {
+		select {
+		case event := <-events:
+			if err := w.handle(event); err != nil {
+				log.Println(fmt.Sprintf("Could not archive %s event: %s", event.Event, err))
+			}
+		case err := <-streamErr:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// catchUp fetches every post newer than the newest archived post, which
+// is nothing but the posts published while the stream was down.
+func (w *watcher) catchUp(ctx context.Context) error {
+	newest := w.state.Source(w.client.Account()).Newest
+
+	if newest == "" {
+		return nil
+	}
+
+	if err := w.client.Sync(ctx, w.filters, newest, ""); err != nil {
+		return err
+	}
+
+	return w.write()
+}
+
+func (w *watcher) handle(event client.StreamEvent) error {
+	switch event.Event {
+	case client.StreamUpdate:
+		if !w.filters.Matches(event.Status, w.client.Account().Id) {
+			return nil
+		}
+
+		if err := w.client.Add(event.Status); err != nil {
+			return err
+		}
+	case client.StreamStatusUpdate:
+		if _, ok := w.state.Posts[event.Status.Id]; !ok {
+			return nil
+		}
+
+		if err := w.client.Refresh(event.Status); err != nil {
+			return err
+		}
+	case client.StreamDelete:
+		postFile, ok := w.state.Posts[event.StatusId]
+
+		if !ok || postFile.DeletedAt != nil {
+			return nil
+		}
+
+		if err := handleDeleted(w.state, event.StatusId, w.deletedPolicy, time.Now()); err != nil {
+			return err
+		}
+
+		return w.state.Save()
+	}
+
+	return w.write()
+}
+
+// write writes the posts the client holds, and records them in the state.
+func (w *watcher) write() error {
+	posts := w.client.Posts()
+
+	if len(posts) == 0 {
+		return nil
+	}
+
+	fileWriter, err := w.newWriter()
+
+	if err != nil {
+		return err
+	}
+
+	cursors := cursorsAdvance
+
+	// The posts of a filtered watch leave gaps between their bounds, which
+	// a later sync would then skip.
+	if w.filters.Filtered() {
+		cursors = cursorsKeep
+	}
+
+	if err := archivePosts(context.Background(), w.state, &fileWriter, cursors, w.client); err != nil {
+		return err
+	}
+
+	stats := fileWriter.Stats()
+	log.Println(fmt.Sprintf("Archived %d posts: created %d files, updated %d, %d unchanged, %d edited locally", len(posts), stats.Created, stats.Updated, stats.Unchanged, stats.Edited))
+
+	return nil
+}